@@ -0,0 +1,248 @@
+package funnel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingSuffix is the suffix of the rotated files yet to be archived
+const pendingSuffix = ".pending"
+
+// archiveJob is a rotated file to be archived, along with
+// the config at the time of its rotation
+type archiveJob struct {
+	cfg *Config
+	// pending is empty for just removing the old files
+	pending string
+}
+
+// archiver compresses the rotated files, gives them their final names and removes
+// the old ones in the background, so that reading lines is not held up by it.
+// On rollover, the active file is moved aside under a pending name. The pending
+// files in the log dir are the real queue, so any work left at exit is done on the next start
+type archiver struct {
+	c    *Consumer
+	jobs chan archiveJob
+	done chan struct{}
+
+	mu sync.Mutex
+	// missed is set if a job did not fit in the queue. Its file is then picked up from the log dir
+	missed *Config
+}
+
+// newArchiver starts the archiver. It first finishes the work left from before
+func newArchiver(c *Consumer, cfg *Config) *archiver {
+	a := &archiver{
+		c:    c,
+		jobs: make(chan archiveJob, cfg.ArchiveQueueSize),
+		done: make(chan struct{}),
+	}
+	go a.run(cfg)
+	return a
+}
+
+func (a *archiver) run(cfg *Config) {
+	defer close(a.done)
+	a.recover(cfg)
+	for job := range a.jobs {
+		if job.pending != "" {
+			if err := a.archive(job.cfg, job.pending); err != nil {
+				a.c.reportError(err)
+			}
+		}
+		a.sweep(job.cfg)
+		a.recoverMissed()
+	}
+	a.recoverMissed()
+}
+
+// recoverMissed archives the files which did not fit in the queue, if any
+func (a *archiver) recoverMissed() {
+	for {
+		a.mu.Lock()
+		missed := a.missed
+		a.missed = nil
+		a.mu.Unlock()
+		if missed == nil {
+			return
+		}
+		a.recover(missed)
+	}
+}
+
+// add queues the rotated file. If the queue is full, rollover does not wait for it
+func (a *archiver) add(cfg *Config, pending string) {
+	select {
+	case a.jobs <- archiveJob{cfg: cfg, pending: pending}:
+	default:
+		a.mu.Lock()
+		a.missed = cfg
+		a.mu.Unlock()
+		a.c.Logger.Warning("archive queue is full, " + pending + " will be archived later")
+	}
+}
+
+// sweepLater queues the removal of old files. It is dropped if the queue is full,
+// as old files are removed after every job anyway
+func (a *archiver) sweepLater(cfg *Config) {
+	select {
+	case a.jobs <- archiveJob{cfg: cfg}:
+	default:
+	}
+}
+
+// close waits for the queued jobs to be done, and stops the archiver
+func (a *archiver) close() {
+	close(a.jobs)
+	<-a.done
+}
+
+// recover archives all the pending files in the log dir, oldest first,
+// and then removes the old files
+func (a *archiver) recover(cfg *Config) {
+	// Nothing is being compressed while this runs, so any compressed file
+	// still under its temporary name was left by a crash
+	if err := removeTempFiles(cfg); err != nil {
+		a.c.reportError(err)
+	}
+	pending, err := pendingFiles(cfg)
+	if err != nil {
+		a.c.reportError(err)
+		return
+	}
+	for _, fileName := range pending {
+		if err := a.archive(cfg, fileName); err != nil {
+			a.c.reportError(err)
+			return
+		}
+	}
+	a.sweep(cfg)
+}
+
+// archive compresses the pending file if needed, and then renames it as per the rename policy
+func (a *archiver) archive(cfg *Config, pending string) error {
+	t, _ := pendingTime(cfg, pending)
+	src := path.Join(cfg.DirName, pending)
+	suffix := ""
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		// It was compressed before a restart, or it is already done
+		if suffix = compressedSuffix(src); suffix == "" {
+			return nil
+		}
+	} else if cfg.compression() != "none" {
+		// If it could not be compressed, it is kept as it is
+		_, err := a.c.tryStageWith(cfg, StageRotation, "compressing "+pending, func() error {
+			var err error
+			suffix, err = compressFile(src, cfg.compression(), cfg.CompressionLevel)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := a.c.tryStageWith(cfg, StageRotation, "renaming "+pending, func() error {
+		var err error
+		if cfg.FileRenamePolicy == "serial" {
+			_, err = moveFileSerial(cfg, pending+suffix, t, suffix)
+		} else {
+			_, err = moveFileTimestamp(cfg, pending+suffix, t, suffix)
+		}
+		return err
+	})
+	return err
+}
+
+// sweep removes the old files
+func (a *archiver) sweep(cfg *Config) {
+	_, err := a.c.tryStageWith(cfg, StageRotation, "deleting old files", func() error {
+		return a.c.deleteFiles(cfg)
+	})
+	if err != nil {
+		a.c.reportError(err)
+	}
+}
+
+// compressedSuffix returns the suffix of the compressed file, if it is there
+func compressedSuffix(fileName string) string {
+	for _, suffix := range compressedSuffixes {
+		if _, err := os.Stat(fileName + suffix); err == nil {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// pendingName returns the name the active file is moved to on rotation
+func pendingName(cfg *Config, t time.Time) string {
+	return "." + cfg.ActiveFileName + "." + strconv.FormatInt(t.UnixNano(), 10) + pendingSuffix
+}
+
+// pendingTime returns the time of rotation of a pending file
+func pendingTime(cfg *Config, fileName string) (time.Time, bool) {
+	prefix := "." + cfg.ActiveFileName + "."
+	if !strings.HasPrefix(fileName, prefix) || !strings.HasSuffix(fileName, pendingSuffix) {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(fileName, prefix), pendingSuffix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// removeTempFiles removes the half done compressed files of the pending files
+func removeTempFiles(cfg *Config) error {
+	files, err := ioutil.ReadDir(cfg.DirName)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		fileName := strings.TrimSuffix(file.Name(), ".tmp")
+		for _, suffix := range compressedSuffixes {
+			fileName = strings.TrimSuffix(fileName, suffix)
+		}
+		if _, ok := pendingTime(cfg, fileName); !ok {
+			continue
+		}
+		if err := os.Remove(path.Join(cfg.DirName, file.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingFiles returns the pending files in the log dir, oldest first
+func pendingFiles(cfg *Config) ([]string, error) {
+	files, err := ioutil.ReadDir(cfg.DirName)
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time)
+	var pending []string
+	for _, file := range files {
+		// The compressed ones are listed by their pending name
+		fileName := file.Name()
+		for _, suffix := range compressedSuffixes {
+			fileName = strings.TrimSuffix(fileName, suffix)
+		}
+		if _, ok := times[fileName]; ok {
+			continue
+		}
+		if t, ok := pendingTime(cfg, fileName); ok {
+			times[fileName] = t
+			pending = append(pending, fileName)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return times[pending[i]].Before(times[pending[j]]) })
+	return pending, nil
+}
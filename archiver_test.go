@@ -0,0 +1,106 @@
+package funnel
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readGzipFile(t *testing.T, fileName string) string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestArchiverRecovery(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.FileRenamePolicy = "serial"
+	c.Config.Gzip = true
+
+	// Files left by a funnel which stopped while archiving them
+	now := time.Now()
+	first := pendingName(c.Config, now.Add(-3*time.Minute))
+	second := pendingName(c.Config, now.Add(-2*time.Minute))
+	third := pendingName(c.Config, now.Add(-time.Minute))
+	if err := ioutil.WriteFile(path.Join(dir, first), []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// This one was compressed, but not renamed
+	if err := ioutil.WriteFile(path.Join(dir, second), []byte("second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipFile(path.Join(dir, second)); err != nil {
+		t.Fatal(err)
+	}
+	// This one was being compressed
+	if err := ioutil.WriteFile(path.Join(dir, third), []byte("third\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, third+".gz.tmp"), []byte("half done"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// This one was being compressed with another codec, before a change in the config
+	if err := ioutil.WriteFile(path.Join(dir, third+".zst.tmp"), []byte("half done"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Start(strings.NewReader("fourth\n"))
+
+	var fileNames []string
+	for _, file := range readTestDir(t, dir) {
+		fileNames = append(fileNames, file.Name())
+	}
+	sort.Strings(fileNames)
+	want := []string{"out.log.1.gz", "out.log.2.gz", "out.log.3.gz", "out.log.4.gz"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Fatalf("Incorrect files. Expected %v, Got %v", want, fileNames)
+	}
+	for i, data := range []string{"fourth\n", "third\n", "second\n", "first\n"} {
+		if got := readGzipFile(t, path.Join(dir, want[i])); got != data {
+			t.Errorf("Incorrect data in %s. Expected %q, Got %q", want[i], data, got)
+		}
+	}
+}
+
+func TestArchiverQueueFull(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	// Every rotated file misses the queue, and is picked up from the log dir
+	c.Config.ArchiveQueueSize = 0
+	c.Config.FileRenamePolicy = "serial"
+
+	f, err := os.Open("testdata/file_84lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	c.Start(f)
+
+	var fileNames []string
+	for _, file := range readTestDir(t, dir) {
+		fileNames = append(fileNames, file.Name())
+	}
+	sort.Strings(fileNames)
+	want := []string{"out.log.1", "out.log.2", "out.log.3"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files. Expected %v, Got %v", want, fileNames)
+	}
+}
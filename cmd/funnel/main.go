@@ -16,32 +16,45 @@ const (
 )
 
 func main() {
+	// "funnel rotate" asks the running funnel to rotate the file
+	if len(os.Args) == 2 && os.Args[1] == funnel.CommandRotate {
+		reply, err := funnel.SendCommand(newViper(), funnel.CommandRotate)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(reply)
+		return
+	}
+
 	logger, err := syslog.New(syslog.LOG_ERR, AppName)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	// Verifying whether the app has a piped stdin or not
-	fi, err := os.Stdin.Stat()
-	if err != nil {
-		fmt.Println(err)
+	// With the run command, funnel launches the app itself.
+	// Otherwise, the app has to pipe its output to funnel
+	command, supervise := runCommand(os.Args[1:])
+	if supervise && len(command) == 0 {
+		fmt.Println("Usage: " + AppName + " run -- /path/to/app [args...]")
 		os.Exit(1)
 	}
-	if fi.Mode()&os.ModeNamedPipe == 0 {
-		fmt.Println("No pipe found to consume data from.")
-		os.Exit(1)
+	if !supervise {
+		// Verifying whether the app has a piped stdin or not
+		fi, err := os.Stdin.Stat()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			fmt.Println("No pipe found to consume data from.")
+			os.Exit(1)
+		}
 	}
 
-	// Setting the config file name and the locations to search for the config
-	v := viper.New()
-	v.SetConfigName(AppName)
-	v.AddConfigPath("/etc/" + AppName + "/")
-	v.AddConfigPath("$HOME/.config/" + AppName + "/")
-	v.AddConfigPath(".")
-
 	// Read config
-	// The outputWriter is nil if its file output
-	cfg, reloadChan, outputWriter, err := funnel.GetConfig(v, logger)
+	// The writer of the file output is set up by the consumer itself
+	cfg, reloadChan, outputs, err := funnel.GetConfig(newViper(), logger)
 	if err != nil {
 		fmt.Println("Error in config file: ", err)
 		os.Exit(1)
@@ -56,7 +69,51 @@ func main() {
 		LineProcessor: lp,
 		ReloadChan:    reloadChan,
 		Logger:        logger,
-		Writer:        outputWriter,
+		Outputs:       outputs,
+	}
+	if !supervise {
+		c.Start(os.Stdin)
+		os.Exit(c.ExitCode())
+	}
+
+	// Exit with the same code as the app. If the app exited cleanly,
+	// exit with the code telling whether its lines were all written
+	s := &funnel.Supervisor{
+		Consumer: c,
+		Command:  command,
+	}
+	code, err := s.Run()
+	if err != nil {
+		fmt.Println(err)
+		logger.Err(err.Error())
+		os.Exit(1)
+	}
+	if code == 0 {
+		code = c.ExitCode()
+	}
+	os.Exit(code)
+}
+
+// newViper returns a viper instance which looks for the config file in the usual places
+func newViper() *viper.Viper {
+	// Setting the config file name and the locations to search for the config
+	v := viper.New()
+	v.SetConfigName(AppName)
+	v.AddConfigPath("/etc/" + AppName + "/")
+	v.AddConfigPath("$HOME/.config/" + AppName + "/")
+	v.AddConfigPath(".")
+	return v
+}
+
+// runCommand returns the app to run, if funnel was invoked as
+// "funnel run -- /path/to/app args". The "--" is optional
+func runCommand(args []string) ([]string, bool) {
+	if len(args) == 0 || args[0] != "run" {
+		return nil, false
+	}
+	args = args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
 	}
-	c.Start(os.Stdin)
+	return args, true
 }
@@ -0,0 +1,157 @@
+package funnel
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+// codec is a way of compressing the rotated files
+type codec struct {
+	name   string
+	suffix string
+	// maxLevel is the highest compression level. Level 0 is the default of the codec
+	maxLevel  int
+	newWriter func(w io.Writer, level int, name string) (io.WriteCloser, error)
+}
+
+// The dictionary sizes of the xz presets, for every level
+var xzDictCaps = []int{8 << 20, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// codecs are all the codecs supported
+var codecs = []codec{
+	{
+		name:     "gzip",
+		suffix:   ".gz",
+		maxLevel: gzip.BestCompression,
+		newWriter: func(w io.Writer, level int, name string) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			zw, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				return nil, err
+			}
+			zw.Name = name
+			return zw, nil
+		},
+	},
+	{
+		name:     "zstd",
+		suffix:   ".zst",
+		maxLevel: 22,
+		newWriter: func(w io.Writer, level int, name string) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+	},
+	{
+		name:     "lz4",
+		suffix:   ".lz4",
+		maxLevel: 9,
+		newWriter: func(w io.Writer, level int, name string) (io.WriteCloser, error) {
+			zw := lz4.NewWriter(w)
+			zw.Header.CompressionLevel = level
+			return zw, nil
+		},
+	},
+	{
+		name:     "xz",
+		suffix:   ".xz",
+		maxLevel: 9,
+		newWriter: func(w io.Writer, level int, name string) (io.WriteCloser, error) {
+			return xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(w)
+		},
+	},
+}
+
+// compressedSuffixes are the suffixes of the compressed rotated files
+var compressedSuffixes = func() []string {
+	var suffixes []string
+	for _, c := range codecs {
+		suffixes = append(suffixes, c.suffix)
+	}
+	return suffixes
+}()
+
+// getCodec returns the codec with the given name
+func getCodec(name string) (codec, bool) {
+	for _, c := range codecs {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return codec{}, false
+}
+
+// compression returns the codec to compress the rotated files with, or none.
+// The gzip setting is used if the compression is not set
+func (cfg *Config) compression() string {
+	if cfg.Compression != "" {
+		return cfg.Compression
+	}
+	if cfg.Gzip {
+		return "gzip"
+	}
+	return "none"
+}
+
+// compressFile compresses the file, and removes it once done. The compressed file
+// is written under a temporary name first, so that it is never left half done.
+// It returns the suffix of the compressed file
+func compressFile(sourcePath, codecName string, level int) (string, error) {
+	c, ok := getCodec(codecName)
+	if !ok {
+		return "", ErrInvalidCompression
+	}
+	reader, err := os.Open(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	target := sourcePath + c.suffix
+	tmp := target + ".tmp"
+	// Open new compressed stream
+	writer, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	archiver, err := c.newWriter(writer, level, path.Base(sourcePath))
+	if err == nil {
+		// Write to the compressed stream
+		_, err = io.Copy(archiver, reader)
+		if cerr := archiver.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = writer.Sync()
+	}
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	// Remove the old file once done
+	return c.suffix, os.Remove(sourcePath)
+}
+
+func gzipFile(sourcePath string) error {
+	_, err := compressFile(sourcePath, "gzip", 0)
+	return err
+}
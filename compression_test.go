@@ -0,0 +1,120 @@
+package funnel
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/spf13/viper"
+	"github.com/ulikunitz/xz"
+)
+
+func TestCompressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		"lz4":  func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil },
+		"xz":   func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+	}
+	content := "compression test content\n"
+	for _, c := range codecs {
+		for _, level := range []int{0, 1, c.maxLevel} {
+			fileName := path.Join(dir, "out.log."+c.name)
+			if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			suffix, err := compressFile(fileName, c.name, level)
+			if err != nil {
+				t.Fatalf("Unexpected error for %s at level %d - %v", c.name, level, err)
+			}
+			if suffix != c.suffix {
+				t.Errorf("Incorrect suffix for %s. Expected %s, Got %s", c.name, c.suffix, suffix)
+			}
+			if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be removed", fileName)
+			}
+
+			f, err := os.Open(fileName + suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := readers[c.name](f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(r)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Errorf("Incorrect content for %s at level %d. Expected %q, Got %q", c.name, level, content, data)
+			}
+			os.Remove(fileName + suffix)
+		}
+	}
+}
+
+func TestCompressionConfig(t *testing.T) {
+	tests := []struct {
+		compression string
+		gzip        bool
+		level       int
+		err         error
+	}{
+		{"zstd", false, 19, nil},
+		{"none", true, 15, nil},
+		{"bzip2", false, 0, ErrInvalidCompression},
+		{"gzip", false, 10, &ConfigValueError{CompressionLevel}},
+		// The level is checked against gzip if the compression is not set
+		{"", true, 15, &ConfigValueError{CompressionLevel}},
+		{"", false, 15, nil},
+	}
+	for _, test := range tests {
+		v := viper.New()
+		setDefaults(v)
+		v.Set(Compression, test.compression)
+		v.Set(Gzip, test.gzip)
+		v.Set(CompressionLevel, test.level)
+		err := validateConfig(v)
+		if (err == nil) != (test.err == nil) || (err != nil && err.Error() != test.err.Error()) {
+			t.Errorf("Incorrect error for %s at level %d. Expected %v, Got %v", test.compression, test.level, test.err, err)
+		}
+	}
+
+	// The gzip setting is used if the compression is not set
+	if c := (&Config{Gzip: true}).compression(); c != "gzip" {
+		t.Errorf("Expected gzip, Got %s", c)
+	}
+}
+
+func TestRenameFileSerialCompressed(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+
+	// Files compressed with different codecs over time
+	for _, name := range []string{cfg.ActiveFileName, "out.log.1.zst", "out.log.2.xz", "out.log.3.lz4", "out.log.4.gz"} {
+		if err := ioutil.WriteFile(path.Join(cfg.DirName, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := renameFileSerial(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"out.log.1", "out.log.2.zst", "out.log.3.xz", "out.log.4.lz4", "out.log.5.gz"} {
+		if _, err := os.Stat(path.Join(cfg.DirName, name)); err != nil {
+			t.Errorf("Expected %s to be there - %v", name, err)
+		}
+	}
+}
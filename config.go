@@ -3,8 +3,13 @@ package funnel
 import (
 	"errors"
 	"log/syslog"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
@@ -15,15 +20,75 @@ const (
 	// config keys
 	LoggingDirectory         = "logging.directory"
 	LoggingActiveFileName    = "logging.active_file_name"
+	LeftoverFilePolicy       = "logging.leftover_file_policy"
 	RotationMaxLines         = "rotation.max_lines"
 	RotationMaxFileSizeBytes = "rotation.max_file_size_bytes"
+	RotationInterval         = "rotation.interval"
+	RotationAlign            = "rotation.align"
+	RotationTimezone         = "rotation.timezone"
 	FlushingTimeIntervalSecs = "flushing.time_interval_secs"
 	PrependValue             = "misc.prepend_value"
 	FileRenamePolicy         = "rollup.file_rename_policy"
+	FileNameTemplate         = "rollup.file_name_template"
 	MaxAge                   = "rollup.max_age"
 	MaxCount                 = "rollup.max_count"
+	MaxTotalBytes            = "rollup.max_total_bytes"
+	SweepIntervalSecs        = "rollup.sweep_interval_secs"
+	ArchiveQueueSize         = "rollup.archive_queue_size"
 	Gzip                     = "rollup.gzip"
+	Compression              = "rollup.compression"
+	CompressionLevel         = "rollup.compression_level"
 	Target                   = "target.name"
+	Targets                  = "targets"
+	Routes                   = "routes"
+	DefaultRoute             = "routing.default"
+	FilterInclude            = "filter.include"
+	FilterExclude            = "filter.exclude"
+	Processors               = "processors"
+
+	MultilineStartPattern        = "multiline.start_pattern"
+	MultilineContinuationPattern = "multiline.continuation_pattern"
+	MultilineMaxLines            = "multiline.max_lines"
+	MultilineMaxBytes            = "multiline.max_bytes"
+	MultilineFlushTimeoutMillis  = "multiline.flush_timeout_ms"
+
+	InputMaxLineBytes   = "input.max_line_bytes"
+	InputLongLinePolicy = "input.long_line_policy"
+	InputTruncateMarker = "input.truncate_marker"
+
+	QueueSize   = "queue.size"
+	QueuePolicy = "queue.policy"
+
+	ErrorsProcessor          = "errors.processor"
+	ErrorsOutput             = "errors.output"
+	ErrorsRotation           = "errors.rotation"
+	ErrorsRetryAttempts      = "errors.retry_attempts"
+	ErrorsRetryBackoffMillis = "errors.retry_backoff_ms"
+
+	// These are set in the section of an output target
+	SpoolDirectory = "target.spool.directory"
+	SpoolMaxBytes  = "target.spool.max_bytes"
+
+	DeadLetterFile     = "target.dead_letter.file"
+	DeadLetterMaxBytes = "target.dead_letter.max_bytes"
+	DeadLetterMaxFiles = "target.dead_letter.max_files"
+
+	Retry                     = "target.retry"
+	RetryAttempts             = "target.retry.attempts"
+	RetryInitialBackoffMillis = "target.retry.initial_backoff_ms"
+	RetryMaxBackoffMillis     = "target.retry.max_backoff_ms"
+	RetryBreakerFailures      = "target.retry.breaker_failures"
+	RetryBreakerOpenSecs      = "target.retry.breaker_open_secs"
+
+	ControlSocket = "control.socket"
+
+	ShutdownDrainTimeoutSecs = "shutdown.drain_timeout_secs"
+
+	SupervisorRestart             = "supervisor.restart"
+	SupervisorRestartDelayMillis  = "supervisor.restart_delay_ms"
+	SupervisorRestartMaxDelaySecs = "supervisor.restart_max_delay_secs"
+	SupervisorMaxRestarts         = "supervisor.max_restarts"
+	SupervisorRestartWindowSecs   = "supervisor.restart_window_secs"
 )
 
 var (
@@ -31,8 +96,33 @@ var (
 	ErrInvalidFileRenamePolicy = errors.New(FileRenamePolicy + " can only be timestamp or serial")
 	// ErrInvalidMaxAge is raised for invalid value in max age - life bad suffixes or no integer value at all
 	ErrInvalidMaxAge = errors.New(MaxAge + " must end with either d or h and start with a number")
+	// ErrInvalidRotationInterval is raised if the rotation interval is not a positive duration
+	ErrInvalidRotationInterval = errors.New(RotationInterval + " must be a duration like 1h or 24h")
+	// ErrInvalidCompression is raised for invalid values to the compression
+	ErrInvalidCompression = errors.New(Compression + " can only be gzip, zstd, lz4, xz or none")
+	// ErrInvalidLeftoverFilePolicy is raised for invalid values to the leftover file policy
+	ErrInvalidLeftoverFilePolicy = errors.New(LeftoverFilePolicy + " can only be rotate or append")
+	// ErrInvalidTargets is raised if an entry in the targets list does not have a name
+	ErrInvalidTargets = errors.New("every entry in " + Targets + " must have a name")
+	// ErrInvalidLongLinePolicy is raised for invalid values to the long line policy
+	ErrInvalidLongLinePolicy = errors.New(InputLongLinePolicy + " can only be truncate, split or drop")
+	// ErrInvalidQueuePolicy is raised for invalid values to the queue policy
+	ErrInvalidQueuePolicy = errors.New(QueuePolicy + " can only be block, drop-newest or drop-oldest")
+	// ErrInvalidRestartPolicy is raised for invalid values to the restart policy
+	ErrInvalidRestartPolicy = errors.New(SupervisorRestart + " can only be no, on-failure or always")
+	// ErrEmptyFilterRule is raised if a filter rule does not have any condition
+	ErrEmptyFilterRule = errors.New("every filter rule must have at least one of regex, field or level")
 )
 
+// DuplicateTargetError is raised if the same target is listed more than once
+type DuplicateTargetError struct {
+	Target string
+}
+
+func (e *DuplicateTargetError) Error() string {
+	return "Target " + e.Target + " is listed more than once"
+}
+
 // ConfigValueError holds the error value if a config key contains
 // an invalid value
 type ConfigValueError struct {
@@ -45,27 +135,74 @@ func (e *ConfigValueError) Error() string {
 
 // Config holds all the config settings
 type Config struct {
-	DirName        string
-	ActiveFileName string
+	DirName            string
+	ActiveFileName     string
+	LeftoverFilePolicy string
 
 	RotationMaxLines int
 	RotationMaxBytes uint64
+	RotationInterval time.Duration
+	RotationAlign    bool
+	RotationTimezone string
 
 	FlushingTimeIntervalSecs int
 
 	PrependValue string
 
-	FileRenamePolicy string
-	MaxAge           int64
-	MaxCount         int
-	Gzip             bool
+	FileRenamePolicy  string
+	FileNameTemplate  string
+	MaxAge            int64
+	MaxCount          int
+	MaxTotalBytes     int64
+	SweepIntervalSecs int
+	ArchiveQueueSize  int
+	Gzip              bool
+	Compression       string
+	CompressionLevel  int
 
-	Target string
+	Targets []string
+
+	Routes       []RouteConfig
+	DefaultRoute []string
+
+	FilterInclude []MatchConfig
+	FilterExclude []MatchConfig
+
+	Processors []map[string]interface{}
+
+	MultilineStartPattern        string
+	MultilineContinuationPattern string
+	MultilineMaxLines            int
+	MultilineMaxBytes            int
+	MultilineFlushTimeoutMillis  int
+
+	InputMaxLineBytes   int
+	InputLongLinePolicy string
+	InputTruncateMarker string
+
+	QueueSize   int
+	QueuePolicy string
+
+	ErrorsProcessor          string
+	ErrorsOutput             string
+	ErrorsRotation           string
+	ErrorsRetryAttempts      int
+	ErrorsRetryBackoffMillis int
+
+	ControlSocket string
+
+	ShutdownDrainTimeoutSecs int
+
+	SupervisorRestart             string
+	SupervisorRestartDelayMillis  int
+	SupervisorRestartMaxDelaySecs int
+	SupervisorMaxRestarts         int
+	SupervisorRestartWindowSecs   int
 }
 
 // GetConfig returns the config struct which is then passed
 // to the consumer
-func GetConfig(v *viper.Viper, logger *syslog.Writer) (*Config, chan *Config, OutputWriter, error) {
+func GetConfig(v *viper.Viper, logger *syslog.Writer) (*Config, chan *Config, []*Output, error) {
 	// Set default values. They are overridden by config file values, if provided
 	setDefaults(v)
 	// Create a chan to signal any config reload events
@@ -87,38 +224,116 @@ func GetConfig(v *viper.Viper, logger *syslog.Writer) (*Config, chan *Config, Ou
 		return nil, reloadChan, nil, err
 	}
 
-	v.WatchConfig()
-	v.OnConfigChange(func(e fsnotify.Event) {
-		if e.Op == fsnotify.Write {
-			if err := validateConfig(v); err != nil {
-				logger.Err(err.Error())
-				return
-			}
-			reloadChan <- getConfigStruct(v)
-		}
-	})
-	// return output writer by passing the viper instance
-	outputWriter, err := GetOutputWriter(v, logger)
+	// return output writers by passing the viper instance
+	outputs, err := GetOutputWriters(v, logger)
 	if err != nil {
 		return nil, reloadChan, nil, err
 	}
 
 	// return struct
-	return getConfigStruct(v), reloadChan, outputWriter, nil
+	cfg := getConfigStruct(v)
+	// The viper instance is not touched here after this
+	watchConfig(v, logger, reloadChan)
+	return cfg, reloadChan, outputs, nil
+}
+
+// watchConfig sends the config on the reload channel whenever the config file is written to,
+// or on SIGHUP, for when the file could not be watched. Viper is not safe for concurrent use,
+// so both are handled in a single goroutine, and the file is watched here instead of by viper
+func watchConfig(v *viper.Viper, logger *syslog.Writer, reloadChan chan *Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	var watchErrs chan error
+	configFile := v.ConfigFileUsed()
+	if configFile != "" {
+		configFile = filepath.Clean(configFile)
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			// The whole dir is watched, to pick up the file being replaced on save
+			if err = watcher.Add(filepath.Dir(configFile)); err != nil {
+				watcher.Close()
+			}
+		}
+		if err != nil {
+			logger.Err("config file can not be watched, reload it with SIGHUP - " + err.Error())
+		} else {
+			events, watchErrs = watcher.Events, watcher.Errors
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				if filepath.Clean(e.Name) != configFile || e.Op&fsnotify.Write != fsnotify.Write {
+					continue
+				}
+			case err := <-watchErrs:
+				logger.Err(err.Error())
+				continue
+			case <-hup:
+				logger.Info("reloading the config on SIGHUP")
+			}
+
+			if err := v.ReadInConfig(); err != nil && v.ConfigFileUsed() != "" {
+				logger.Err(err.Error())
+				continue
+			}
+			if err := validateConfig(v); err != nil {
+				logger.Err(err.Error())
+				continue
+			}
+			reloadChan <- getConfigStruct(v)
+		}
+	}()
 }
 
 func setDefaults(v *viper.Viper) {
 	v.SetDefault(LoggingDirectory, "log")
 	v.SetDefault(LoggingActiveFileName, "out.log")
+	v.SetDefault(LeftoverFilePolicy, "rotate")
 	v.SetDefault(RotationMaxLines, 100000)
 	v.SetDefault(RotationMaxFileSizeBytes, 5000000)
+	v.SetDefault(RotationInterval, "")
+	v.SetDefault(RotationAlign, false)
+	v.SetDefault(RotationTimezone, "Local")
 	v.SetDefault(FlushingTimeIntervalSecs, 5)
 	v.SetDefault(PrependValue, "")
 	v.SetDefault(FileRenamePolicy, "timestamp")
+	v.SetDefault(FileNameTemplate, "")
 	v.SetDefault(MaxAge, "30d")
 	v.SetDefault(MaxCount, 100)
+	v.SetDefault(MaxTotalBytes, 0)
+	v.SetDefault(SweepIntervalSecs, 3600)
+	v.SetDefault(ArchiveQueueSize, 100)
 	v.SetDefault(Gzip, false)
+	v.SetDefault(Compression, "")
+	v.SetDefault(CompressionLevel, 0)
 	v.SetDefault(Target, "file")
+	v.SetDefault(MultilineStartPattern, "")
+	v.SetDefault(MultilineContinuationPattern, "")
+	v.SetDefault(MultilineMaxLines, 500)
+	v.SetDefault(MultilineMaxBytes, 1000000)
+	v.SetDefault(MultilineFlushTimeoutMillis, 1000)
+	v.SetDefault(InputMaxLineBytes, 1000000)
+	v.SetDefault(InputLongLinePolicy, "truncate")
+	v.SetDefault(InputTruncateMarker, "...[truncated]")
+	v.SetDefault(QueueSize, 10000)
+	v.SetDefault(QueuePolicy, "block")
+	v.SetDefault(ErrorsProcessor, PolicySkipAndLog)
+	v.SetDefault(ErrorsOutput, PolicyRetry)
+	v.SetDefault(ErrorsRotation, PolicyRetry)
+	v.SetDefault(ErrorsRetryAttempts, 3)
+	v.SetDefault(ErrorsRetryBackoffMillis, 100)
+	v.SetDefault(ControlSocket, "")
+	v.SetDefault(ShutdownDrainTimeoutSecs, 30)
+	v.SetDefault(SupervisorRestart, "no")
+	v.SetDefault(SupervisorRestartDelayMillis, 1000)
+	v.SetDefault(SupervisorRestartMaxDelaySecs, 60)
+	v.SetDefault(SupervisorMaxRestarts, 5)
+	v.SetDefault(SupervisorRestartWindowSecs, 60)
 }
 
 func validateConfig(v *viper.Viper) error {
@@ -150,6 +365,17 @@ func validateConfig(v *viper.Viper) error {
 		RotationMaxFileSizeBytes,
 		FlushingTimeIntervalSecs,
 		MaxCount,
+		ArchiveQueueSize,
+		MultilineMaxLines,
+		MultilineMaxBytes,
+		MultilineFlushTimeoutMillis,
+		QueueSize,
+		ErrorsRetryAttempts,
+		ErrorsRetryBackoffMillis,
+		SupervisorRestartDelayMillis,
+		SupervisorRestartMaxDelaySecs,
+		SupervisorMaxRestarts,
+		SupervisorRestartWindowSecs,
 	} {
 		// If an integer value was a string, it would come as zero,
 		// hence its invalid
@@ -170,6 +396,130 @@ func validateConfig(v *viper.Viper) error {
 		return ErrInvalidMaxAge
 	}
 
+	// Validate the rotation interval. It is turned off if not set
+	if interval := v.GetString(RotationInterval); interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < 0 {
+			return ErrInvalidRotationInterval
+		}
+	}
+	if _, err := time.LoadLocation(v.GetString(RotationTimezone)); err != nil {
+		return &ConfigValueError{RotationTimezone}
+	}
+
+	// Validate the total size of the rotated files. It is not limited if zero
+	if v.GetInt64(MaxTotalBytes) < 0 {
+		return &ConfigValueError{MaxTotalBytes}
+	}
+	// Validate the sweep interval. The files are only cleaned up on rotation if zero
+	if v.GetInt(SweepIntervalSecs) < 0 {
+		return &ConfigValueError{SweepIntervalSecs}
+	}
+	// Validate the drain timeout. Shutdown waits for as long as it takes if zero
+	if v.GetInt(ShutdownDrainTimeoutSecs) < 0 {
+		return &ConfigValueError{ShutdownDrainTimeoutSecs}
+	}
+
+	// Validate the compression. The gzip setting is used if it is not set
+	rollup := &Config{Compression: v.GetString(Compression), Gzip: v.GetBool(Gzip)}
+	if compression := rollup.compression(); compression != "none" {
+		c, ok := getCodec(compression)
+		if !ok {
+			return ErrInvalidCompression
+		}
+		if level := v.GetInt(CompressionLevel); level < 0 || level > c.maxLevel {
+			return &ConfigValueError{CompressionLevel}
+		}
+	}
+
+	// Validate the file name template. The default names are used if not set
+	if tmpl := v.GetString(FileNameTemplate); tmpl != "" {
+		if _, err := parseFileNameTemplate(tmpl); err != nil {
+			return err
+		}
+	}
+
+	// Validate the leftover file policy
+	switch v.GetString(LeftoverFilePolicy) {
+	case "rotate", "append":
+	default:
+		return ErrInvalidLeftoverFilePolicy
+	}
+
+	// Validate the long line policy
+	switch v.GetString(InputLongLinePolicy) {
+	case "truncate", "split", "drop":
+	default:
+		return ErrInvalidLongLinePolicy
+	}
+
+	// Validate the queue policy
+	switch v.GetString(QueuePolicy) {
+	case "block", "drop-newest", "drop-oldest":
+	default:
+		return ErrInvalidQueuePolicy
+	}
+
+	// Validate the error policies
+	for _, key := range []string{ErrorsProcessor, ErrorsOutput, ErrorsRotation} {
+		switch v.GetString(key) {
+		case PolicyAbort, PolicySkipAndLog, PolicyRetry:
+		default:
+			return &ConfigValueError{key}
+		}
+	}
+
+	// Validate the restart policy
+	switch v.GetString(SupervisorRestart) {
+	case "no", "on-failure", "always":
+	default:
+		return ErrInvalidRestartPolicy
+	}
+
+	// Validate the targets list
+	if v.IsSet(Targets) {
+		targets := getTargetConfigs(v)
+		if len(targets) == 0 {
+			return ErrInvalidTargets
+		}
+		seen := make(map[string]bool)
+		for _, target := range targets {
+			name, ok := target["name"].(string)
+			if !ok || name == "" {
+				return ErrInvalidTargets
+			}
+			if seen[name] {
+				return &DuplicateTargetError{name}
+			}
+			seen[name] = true
+		}
+	}
+
+	// Validate the routes by building the router
+	cfg := getConfigStruct(v)
+	if _, err := GetRouter(cfg); err != nil {
+		return err
+	}
+
+	// Validate the filter rules
+	for _, mc := range append(cfg.FilterInclude, cfg.FilterExclude...) {
+		if mc == (MatchConfig{}) {
+			return ErrEmptyFilterRule
+		}
+	}
+	if _, err := GetLineFilter(cfg); err != nil {
+		return err
+	}
+
+	// Validate the processors by building the chain
+	if _, err := NewProcessorChain(cfg); err != nil {
+		return err
+	}
+
+	// Validate the multi-line patterns
+	if _, err := newMultilineAssembler(cfg); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -177,16 +527,136 @@ func getConfigStruct(v *viper.Viper) *Config {
 	return &Config{
 		DirName:                  v.GetString(LoggingDirectory),
 		ActiveFileName:           v.GetString(LoggingActiveFileName),
+		LeftoverFilePolicy:       v.GetString(LeftoverFilePolicy),
 		RotationMaxLines:         v.GetInt(RotationMaxLines),
 		RotationMaxBytes:         uint64(v.GetInt64(RotationMaxFileSizeBytes)),
+		RotationInterval:         getDuration(v.GetString(RotationInterval)),
+		RotationAlign:            v.GetBool(RotationAlign),
+		RotationTimezone:         v.GetString(RotationTimezone),
 		FlushingTimeIntervalSecs: v.GetInt(FlushingTimeIntervalSecs),
 		PrependValue:             v.GetString(PrependValue),
 		FileRenamePolicy:         v.GetString(FileRenamePolicy),
+		FileNameTemplate:         v.GetString(FileNameTemplate),
 		MaxAge:                   getMaxAgeValue(v.GetString(MaxAge)),
 		MaxCount:                 v.GetInt(MaxCount),
+		MaxTotalBytes:            v.GetInt64(MaxTotalBytes),
+		SweepIntervalSecs:        v.GetInt(SweepIntervalSecs),
+		ArchiveQueueSize:         v.GetInt(ArchiveQueueSize),
 		Gzip:                     v.GetBool(Gzip),
-		Target:                   v.GetString(Target),
+		Compression:              v.GetString(Compression),
+		CompressionLevel:         v.GetInt(CompressionLevel),
+		Targets:                  getTargetNames(v),
+		Routes:                   getRouteConfigs(v),
+		DefaultRoute:             toStringSlice(v.Get(DefaultRoute)),
+		FilterInclude:            getMatchConfigs(v.Get(FilterInclude)),
+		FilterExclude:            getMatchConfigs(v.Get(FilterExclude)),
+		Processors:               getProcessorConfigs(v.Get(Processors)),
+
+		MultilineStartPattern:        v.GetString(MultilineStartPattern),
+		MultilineContinuationPattern: v.GetString(MultilineContinuationPattern),
+		MultilineMaxLines:            v.GetInt(MultilineMaxLines),
+		MultilineMaxBytes:            v.GetInt(MultilineMaxBytes),
+		MultilineFlushTimeoutMillis:  v.GetInt(MultilineFlushTimeoutMillis),
+
+		InputMaxLineBytes:   v.GetInt(InputMaxLineBytes),
+		InputLongLinePolicy: v.GetString(InputLongLinePolicy),
+		InputTruncateMarker: v.GetString(InputTruncateMarker),
+
+		QueueSize:   v.GetInt(QueueSize),
+		QueuePolicy: v.GetString(QueuePolicy),
+
+		ErrorsProcessor:          v.GetString(ErrorsProcessor),
+		ErrorsOutput:             v.GetString(ErrorsOutput),
+		ErrorsRotation:           v.GetString(ErrorsRotation),
+		ErrorsRetryAttempts:      v.GetInt(ErrorsRetryAttempts),
+		ErrorsRetryBackoffMillis: v.GetInt(ErrorsRetryBackoffMillis),
+
+		ControlSocket: v.GetString(ControlSocket),
+
+		ShutdownDrainTimeoutSecs: v.GetInt(ShutdownDrainTimeoutSecs),
+
+		SupervisorRestart:             v.GetString(SupervisorRestart),
+		SupervisorRestartDelayMillis:  v.GetInt(SupervisorRestartDelayMillis),
+		SupervisorRestartMaxDelaySecs: v.GetInt(SupervisorRestartMaxDelaySecs),
+		SupervisorMaxRestarts:         v.GetInt(SupervisorMaxRestarts),
+		SupervisorRestartWindowSecs:   v.GetInt(SupervisorRestartWindowSecs),
+	}
+}
+
+// getProcessorConfigs returns the settings of every entry in the processors list
+func getProcessorConfigs(val interface{}) []map[string]interface{} {
+	var processors []map[string]interface{}
+	for _, settings := range GetTables(val) {
+		if settings == nil {
+			settings = map[string]interface{}{}
+		}
+		processors = append(processors, settings)
 	}
+	return processors
+}
+
+// getMatchConfigs returns the conditions in a list from the config.
+// Entries can either be tables with the conditions, or just strings
+// which are taken as a regex
+func getMatchConfigs(val interface{}) []MatchConfig {
+	entries, ok := val.([]interface{})
+	if !ok {
+		return nil
+	}
+	var mcs []MatchConfig
+	for _, entry := range entries {
+		switch e := entry.(type) {
+		case string:
+			mcs = append(mcs, MatchConfig{Regex: e})
+		case map[string]interface{}:
+			mcs = append(mcs, getMatchConfig(e))
+		default:
+			mcs = append(mcs, MatchConfig{})
+		}
+	}
+	return mcs
+}
+
+// getRouteConfigs returns the settings of every entry in the routes list
+func getRouteConfigs(v *viper.Viper) []RouteConfig {
+	var routes []RouteConfig
+	for _, route := range GetTables(v.Get(Routes)) {
+		routes = append(routes, RouteConfig{
+			Match:   getMatchConfig(route),
+			Outputs: getStringSlice(route, "outputs"),
+		})
+	}
+	return routes
+}
+
+// getTargetConfigs returns the settings of every entry in the targets list.
+// Entries which are not tables are returned as nil maps
+func getTargetConfigs(v *viper.Viper) []map[string]interface{} {
+	return GetTables(v.Get(Targets))
+}
+
+// getTargetNames returns the names of all the targets to write to.
+// If there is no targets list, the single target from the target section is used
+func getTargetNames(v *viper.Viper) []string {
+	if !v.IsSet(Targets) {
+		return []string{v.GetString(Target)}
+	}
+	var names []string
+	for _, target := range getTargetConfigs(v) {
+		name, _ := target["name"].(string)
+		names = append(names, name)
+	}
+	return names
+}
+
+// hasTarget checks whether the given target is one of the targets to write to
+func (cfg *Config) hasTarget(name string) bool {
+	for _, target := range cfg.Targets {
+		if target == name {
+			return true
+		}
+	}
+	return false
 }
 
 func getMaxAgeValue(maxAge string) int64 {
@@ -198,3 +668,57 @@ func getMaxAgeValue(maxAge string) int64 {
 	}
 	return int64(magnitude) * 60 * 60
 }
+
+// getDuration parses a duration from the config. An empty value is zero
+func getDuration(val string) time.Duration {
+	d, _ := time.ParseDuration(val)
+	return d
+}
+
+// getString returns the value of a key in a config table as a string
+func getString(m map[string]interface{}, key string) string {
+	val, ok := m[key]
+	if !ok || val == nil {
+		return ""
+	}
+	return fieldString(val)
+}
+
+// getStringSlice returns the value of a key in a config table as a slice of strings
+func getStringSlice(m map[string]interface{}, key string) []string {
+	return toStringSlice(m[key])
+}
+
+// toStringSlice converts a list value from the config to a slice of strings
+func toStringSlice(v interface{}) []string {
+	switch val := v.(type) {
+	case []string:
+		return val
+	case []interface{}:
+		strs := make([]string, len(val))
+		for i, v := range val {
+			strs[i] = fieldString(v)
+		}
+		return strs
+	case string:
+		return []string{val}
+	}
+	return nil
+}
+
+// GetTables returns the value of a key as a list of tables, which is how
+// viper returns an array of tables from the config. Outputs use it for the
+// tables in their settings
+func GetTables(val interface{}) []map[string]interface{} {
+	var tables []map[string]interface{}
+	switch entries := val.(type) {
+	case []map[string]interface{}:
+		tables = entries
+	case []interface{}:
+		for _, entry := range entries {
+			table, _ := entry.(map[string]interface{})
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
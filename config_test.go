@@ -1,10 +1,13 @@
 package funnel
 
 import (
+	"io/ioutil"
 	"log/syslog"
 	"os"
+	"path"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -25,15 +28,52 @@ func TestSanity(t *testing.T) {
 	tests := []interface{}{
 		"testdir",
 		"testfile",
+		"rotate",
 		100,
 		uint64(4509),
+		time.Duration(0),
+		false,
+		"Local",
 		5,
 		"",
 		"timestamp",
+		"",
 		int64(2592000),
 		100,
+		int64(0),
+		3600,
+		100,
 		false,
-		"file",
+		"",
+		0,
+		[]string{"file"},
+		[]RouteConfig(nil),
+		[]string(nil),
+		[]MatchConfig(nil),
+		[]MatchConfig(nil),
+		[]map[string]interface{}(nil),
+		"",
+		"",
+		500,
+		1000000,
+		1000,
+		1000000,
+		"truncate",
+		"...[truncated]",
+		10000,
+		"block",
+		"skip-and-log",
+		"retry",
+		"retry",
+		3,
+		100,
+		"",
+		30,
+		"no",
+		1000,
+		60,
+		5,
+		60,
 	}
 
 	cfgValue := reflect.ValueOf(cfg).Elem()
@@ -41,7 +81,7 @@ func TestSanity(t *testing.T) {
 	// Iterating through the properties to check everything is good
 	for i := 0; i < cfgValue.NumField(); i++ {
 		v := cfgValue.Field(i).Interface()
-		if v != tests[i] {
+		if !reflect.DeepEqual(v, tests[i]) {
 			t.Errorf("Incorrect value from config. Expected %s, Got %s", tests[i], v)
 		}
 	}
@@ -105,3 +145,49 @@ func TestEnvVars(t *testing.T) {
 		t.Errorf("Failed to set value from env var. Expected %s, Got %s", envValue, cfg.DirName)
 	}
 }
+
+func TestDuplicateTargets(t *testing.T) {
+	v := viper.New()
+	setDefaults(v)
+	v.Set(Targets, []interface{}{
+		map[string]interface{}{"name": "file"},
+		map[string]interface{}{"name": "file"},
+	})
+
+	err := validateConfig(v)
+	if serr, ok := err.(*DuplicateTargetError); !ok || serr.Target != "file" {
+		t.Errorf("Expected DuplicateTargetError for file, Got %v", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := path.Join(dir, "funnel.toml")
+	if err := ioutil.WriteFile(fileName, []byte("[rotation]\nmax_lines = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(fileName)
+	_, reloadChan, _, err := GetConfig(v, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SIGHUP is handled in the same goroutine. It is not sent here, as the goroutines
+	// of the other tests would get it too
+	if err := ioutil.WriteFile(fileName, []byte("[rotation]\nmax_lines = 20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cfg := <-reloadChan:
+		if cfg.RotationMaxLines != 20 {
+			t.Errorf("Incorrect max lines after reload. Expected 20, Got %d", cfg.RotationMaxLines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Config was not reloaded")
+	}
+}
@@ -2,11 +2,14 @@ package funnel
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"log/syslog"
+	"net"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -18,18 +21,39 @@ type Consumer struct {
 	Config        *Config
 	LineProcessor LineProcessor
 	Logger        *syslog.Writer
-	Writer        OutputWriter
+	Outputs       []*Output
 
 	// internal stuff
-	currFile *os.File
-	feed     chan string
+	currFile  *os.File
+	feed      chan inputLine
+	lineBuf   bytes.Buffer
+	router    *Router
+	filter    *LineFilter
+	multiline *multilineAssembler
+	// events holds the multi-line event being assembled for each stream
+	events map[string]*multilineAssembler
+	// eventDeadlines holds the time by which the pending event of each stream
+	// is written, if no more lines come on that stream
+	eventDeadlines map[string]time.Time
+	archiver       *archiver
+	stats          statsTracker
+	supervised     bool
+	// queuePolicy decides what happens to a line when the feed is full
+	queuePolicy string
+	// drainTimeout is how long the shutdown waits for the outputs to be closed
+	drainTimeout time.Duration
+	// failures tracks the runs of output failures, which are logged once per run
+	failures failureRuns
 
 	// channel signallers
 	done         chan struct{}
+	stop         chan struct{}
 	rolloverChan chan struct{}
 	signalChan   chan os.Signal
+	control      net.Listener
 	errChan      chan error
 	wg           sync.WaitGroup
+	shutdownOnce sync.Once
 	ReloadChan   chan *Config
 
 	// variable to track write progress
@@ -37,118 +61,390 @@ type Consumer struct {
 	bytesWritten uint64
 }
 
+// InputStream is a stream of lines to be consumed. If the stream has a name,
+// every line read from it is tagged with the name of the stream
+type InputStream struct {
+	Name   string
+	Reader io.Reader
+}
+
+// inputLine is a line along with the name of the stream it was read from
+type inputLine struct {
+	text   string
+	stream string
+}
+
 // Start takes the input stream and begins reading line by line
 // buffering the output to a file and flushing at set intervals
 func (c *Consumer) Start(inputStream io.Reader) {
-	c.setupSignalHandling()
+	c.StartStreams(InputStream{Reader: inputStream})
+}
+
+// StartStreams is like Start, but reads from all the streams at once.
+// It returns when all of them have ended
+func (c *Consumer) StartStreams(streams ...InputStream) {
 	c.done = make(chan struct{})
-	c.rolloverChan = make(chan struct{})
+	c.stop = make(chan struct{})
+	// A single rotation can be pending, so that asking for one never blocks
+	c.rolloverChan = make(chan struct{}, 1)
+	// The drain timeout is not changed on reload
+	c.drainTimeout = time.Duration(c.Config.ShutdownDrainTimeoutSecs) * time.Second
+	c.shutdownOnce = sync.Once{}
+	c.setupSignalHandling()
 	// A buffer of 1 is kept for the startFeed loop to be able to write an error
 	// and finish the select case. Otherwise, the main loop will get stuck because
 	// line read won't be complete and startFeed won't be able to write the error
 	c.errChan = make(chan error, 1)
+	// Set up the routing rules, if any
+	router, err := GetRouter(c.Config)
+	if err != nil {
+		c.Logger.Err(err.Error())
+		c.stats.fail()
+		return
+	}
+	c.router = router
+	// Set up the filter rules, if any
+	filter, err := GetLineFilter(c.Config)
+	if err != nil {
+		c.Logger.Err(err.Error())
+		c.stats.fail()
+		return
+	}
+	c.filter = filter
+	// Set up the joining of multi-line events, if needed
+	multiline, err := newMultilineAssembler(c.Config)
+	if err != nil {
+		c.Logger.Err(err.Error())
+		c.stats.fail()
+		return
+	}
+	c.multiline = multiline
+	c.events = nil
+	c.linesWritten = 0
+	c.bytesWritten = 0
 	// Check if the target is file, only then create dirs and all
-	if c.Config.Target == "file" {
+	if c.Config.hasTarget("file") {
 		// Make the dir along with parents
 		if err := os.MkdirAll(c.Config.DirName, 0775); err != nil {
 			c.Logger.Err(err.Error())
+			c.stats.fail()
 			return
 		}
 
-		// Create the file
-		if err := c.createNewFile(); err != nil {
+		// Create the file, or pick up the one left from before
+		if err := c.startActiveFile(); err != nil {
 			c.Logger.Err(err.Error())
+			c.stats.fail()
 			return
 		}
+
+		// Finish archiving the files left from before, and clean up the old ones
+		c.archiver = newArchiver(c, c.Config)
 	}
 
-	// Create the line feed channel and start the feed goroutine
-	c.feed = make(chan string)
+	// Get the readers to the input streams
+	readers := make([]*lineReader, len(streams))
+	for i, s := range streams {
+		readers[i] = newLineReader(s.Reader, c.Config, c.longLine)
+	}
+
+	// Create the line feed queue and start the feed goroutine.
+	// The size of the queue is not changed on reload
+	c.feed = make(chan inputLine, c.Config.QueueSize)
+	c.queuePolicy = c.Config.QueuePolicy
 	go c.startFeed()
 
-	// Get the reader to the input stream and set initial counters
-	reader := bufio.NewReader(inputStream)
-	c.linesWritten = 0
-	c.bytesWritten = 0
+	// Listen for commands, like to rotate the file
+	if err := c.startControl(); err != nil {
+		c.Logger.Err(err.Error())
+	}
 
-	// start a for-select loop to wait until main loop is done, or catch errors
-outer:
-	for {
-		select {
-		case err := <-c.errChan: // error channel to get any errors happening
-			// elsewhere. After printing to stderr, it breaks from the loop
-			c.Logger.Err(err.Error())
-			break outer
-		default:
-			// This will return a line until delimiter
-			// If delimiter is not found, it returns the line with error
-			// so line will always be available
-			// Then we check for error and quit
-			line, err := reader.ReadString('\n')
-			// Send to feed
-			c.feed <- line
+	// Read every stream in its own goroutine
+	var reading sync.WaitGroup
+	readDone := make(chan struct{})
+	for i, s := range streams {
+		reading.Add(1)
+		go func(reader *lineReader, name string) {
+			defer reading.Done()
+			c.readStream(reader, name)
+		}(readers[i], s.Name)
+	}
+	go func() {
+		reading.Wait()
+		close(readDone)
+	}()
 
-			if err != nil {
-				if err != io.EOF {
-					c.Logger.Err(err.Error())
-				}
-				break outer
-			}
-		}
+	// wait until all the streams have ended, or catch errors
+	select {
+	case err := <-c.errChan: // error channel to get any errors happening
+		// elsewhere. After printing to stderr, it stops reading
+		c.Logger.Err(err.Error())
+		c.stats.fail()
+	case <-readDone:
 	}
-	// work is done, signalling done channel
-	c.wg.Add(1)
-	c.done <- struct{}{}
-	c.wg.Wait()
+	close(c.stop)
+	c.stopControl()
+	c.shutdown()
 	// quitting from signal handler
+	signal.Stop(c.signalChan)
 	close(c.signalChan)
 }
 
-func (c *Consumer) cleanUp() {
-	var err error
-	// If target is a file, close the file handles
-	if c.Config.Target == "file" {
-		// Close file handle
-		if err = c.currFile.Sync(); err != nil {
-			c.Logger.Err(err.Error())
+// readStream sends the lines of a stream to the feed until the stream ends
+func (c *Consumer) readStream(reader *lineReader, name string) {
+	for {
+		// This will return a line until delimiter
+		// If delimiter is not found, it returns the line with error
+		// so line will always be available
+		// Then we check for error and quit
+		line, err := reader.readLine()
+		// Send to feed. Nothing is left to send if the stream
+		// ended right after a delimiter
+		if line != "" && !c.enqueue(inputLine{line, name}) {
 			return
 		}
 
-		if err = c.currFile.Close(); err != nil {
-			c.Logger.Err(err.Error())
+		if err != nil {
+			if err != io.EOF {
+				c.Logger.Err(err.Error())
+			}
 			return
 		}
+	}
+}
 
-		// Rename the currfile to a rolled up one
-		var fileName string
-		if fileName, err = c.rename(); err != nil {
-			c.Logger.Err(err.Error())
-			return
+func (c *Consumer) cleanUp() {
+	// Close every output independently, so that one failing target
+	// does not prevent the others from being closed
+	for _, o := range c.Outputs {
+		// If target is a file, close the file handles
+		if o.Name == "file" {
+			if err := c.closeFile(); err != nil {
+				c.Logger.Err(err.Error())
+			}
+			continue
+		}
+		// else call the Close function on the writer
+		if err := o.Writer.Close(); err != nil {
+			c.Logger.Err((&OutputError{o.Name, err}).Error())
 		}
+	}
+	// Wait for the rotated files to be archived
+	if c.archiver != nil {
+		c.archiver.close()
+		c.archiver = nil
+	}
+}
 
-		if err = c.compress(fileName); err != nil {
-			c.Logger.Err(err.Error())
-			return
+func (c *Consumer) closeFile() error {
+	var err error
+	// Close file handle
+	if err = c.currFile.Sync(); err != nil {
+		return err
+	}
+
+	if err = c.currFile.Close(); err != nil {
+		return err
+	}
+
+	// Rename the currfile to a rolled up one
+	var pending string
+	if pending, err = c.rename(); err != nil {
+		return err
+	}
+	c.archiver.add(c.Config, pending)
+	return nil
+}
+
+// startActiveFile creates the active file. If one is left from before, like when funnel
+// was killed, it is rotated or written to as per the leftover file policy
+func (c *Consumer) startActiveFile() error {
+	fileName := path.Join(c.Config.DirName, c.Config.ActiveFileName)
+	fi, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return c.createNewFile()
+	}
+	if err != nil {
+		return err
+	}
+	size := strconv.FormatInt(fi.Size(), 10)
+
+	if c.Config.LeftoverFilePolicy == "append" {
+		if err := c.appendToFile(); err != nil {
+			return err
 		}
-	} else { // else call the Close function on the writer
-		c.Writer.Close()
+		c.Logger.Warning("found " + fileName + " left from before with " + size + " bytes, appending to it")
+		// It is rotated once it is full with what was there
+		c.bytesWritten = uint64(fi.Size())
+		return nil
+	}
+
+	// The pending file is archived along with the rotated ones
+	pending, err := c.rename()
+	if err != nil {
+		return err
+	}
+	c.Logger.Warning("found " + fileName + " left from before with " + size + " bytes, rotated it to " + pending)
+	if c.archiver != nil {
+		c.archiver.add(c.Config, pending)
 	}
+	return c.createNewFile()
 }
 
 func (c *Consumer) createNewFile() error {
-	f, err := os.OpenFile(path.Join(c.Config.DirName, c.Config.ActiveFileName),
-		os.O_CREATE|os.O_WRONLY|os.O_EXCL,
-		0644)
+	return c.openActiveFile(os.O_CREATE | os.O_WRONLY | os.O_EXCL)
+}
+
+// appendToFile opens the existing active file to write at its end
+func (c *Consumer) appendToFile() error {
+	return c.openActiveFile(os.O_WRONLY | os.O_APPEND)
+}
+
+func (c *Consumer) openActiveFile(flag int) error {
+	f, err := os.OpenFile(path.Join(c.Config.DirName, c.Config.ActiveFileName), flag, 0644)
 	if err != nil {
 		return err
 	}
 	c.currFile = f
 	// Embedding buffered writer in another struct to satisfy the OutputWriter interface
 	// This is because in the consume loop, functions are called directly on the writer
-	c.Writer = &FileOutput{bufio.NewWriter(c.currFile)}
+	c.fileOutput().Writer = &FileOutput{bufio.NewWriter(c.currFile)}
 	return nil
 }
 
+// fileOutput returns the output of the file target, adding it to
+// the list of outputs if it is not there yet
+func (c *Consumer) fileOutput() *Output {
+	for _, o := range c.Outputs {
+		if o.Name == "file" {
+			return o
+		}
+	}
+	o := &Output{Name: "file"}
+	c.Outputs = append(c.Outputs, o)
+	return o
+}
+
+// targetsChanged checks whether the config adds or removes a target other than file.
+// Their outputs are only set up at the start, so such a change needs a restart
+func (c *Consumer) targetsChanged(cfg *Config) bool {
+	live := make(map[string]bool)
+	for _, o := range c.Outputs {
+		if o.Name != "file" {
+			live[o.Name] = true
+		}
+	}
+	n := 0
+	for _, target := range cfg.Targets {
+		if target == "file" {
+			continue
+		}
+		if !live[target] {
+			return true
+		}
+		n++
+	}
+	return n != len(live)
+}
+
+// checkRouteOutputs logs every output the routes send lines to which is not running,
+// like the file target when its file could not be created on reload
+func (c *Consumer) checkRouteOutputs() {
+	if c.router == nil {
+		return
+	}
+	running := make(map[string]bool)
+	for _, o := range c.Outputs {
+		running[o.Name] = true
+	}
+	for _, name := range c.router.outputs() {
+		if !running[name] {
+			c.Logger.Err("route output " + name + " is not running, lines routed only to it are lost")
+		}
+	}
+}
+
+// removeFileOutput removes the output of the file target from the list of outputs
+func (c *Consumer) removeFileOutput() {
+	for i, o := range c.Outputs {
+		if o.Name == "file" {
+			c.Outputs = append(c.Outputs[:i], c.Outputs[i+1:]...)
+			return
+		}
+	}
+}
+
+// writeLine passes the line through the line processor once
+// and then writes the result to every output the line is routed to.
+// Errors are handled as per the error policies of the stages.
+// It returns true if the line was written to at least one output
+func (c *Consumer) writeLine(line string) (bool, error) {
+	ok, err := c.tryStage(StageProcessor, "processing a line", func() error {
+		c.lineBuf.Reset()
+		return c.LineProcessor.Write(&c.lineBuf, line)
+	})
+	if !ok {
+		c.stats.lineLost()
+		return false, err
+	}
+	// Dropped by the processor
+	if c.lineBuf.Len() == 0 {
+		return false, nil
+	}
+
+	// Without any routes, every line goes to every output
+	var routed map[string]bool
+	if c.router != nil {
+		routed = c.router.Route(line)
+	}
+
+	var errs []*OutputError
+	lost := false
+	sent := false
+	written := false
+	for _, o := range c.Outputs {
+		if routed != nil && !routed[o.Name] {
+			continue
+		}
+		sent = true
+		ok, err := c.tryStage(StageOutput, "writing to output "+o.Name, func() error {
+			_, err := o.Writer.Write(c.lineBuf.Bytes())
+			return err
+		})
+		if err != nil {
+			errs = append(errs, &OutputError{o.Name, err})
+		}
+		lost = lost || !ok
+		written = written || ok
+	}
+	// The line is routed only to outputs which are not running
+	if lost || !sent {
+		c.stats.lineLost()
+	}
+	return written, combineOutputErrors(errs)
+}
+
+// flush flushes every output. A failing output does not prevent
+// the others from being flushed
+func (c *Consumer) flush() error {
+	var errs []*OutputError
+	for _, o := range c.Outputs {
+		var flushErr error
+		ok, err := c.tryStage(StageOutput, "flushing output "+o.Name, func() error {
+			flushErr = o.Writer.Flush()
+			return flushErr
+		})
+		if err != nil {
+			errs = append(errs, &OutputError{o.Name, err})
+		}
+		// The lines rejected by the output are not written anywhere else
+		if rejErr, rejected := flushErr.(*RejectedError); rejected && !ok {
+			c.stats.linesLost(len(rejErr.Lines))
+		}
+	}
+	return combineOutputErrors(errs)
+}
+
 func (c *Consumer) rollOverCondition() bool {
 	// Return true if either lines written has exceeded
 	// or bytes written has exceeded
@@ -156,36 +452,80 @@ func (c *Consumer) rollOverCondition() bool {
 		c.bytesWritten >= c.Config.RotationMaxBytes
 }
 
+// rotationDelay returns the time till the next time-based rotation.
+// It is zero if the rotation interval is not set
+func (c *Consumer) rotationDelay() time.Duration {
+	if c.Config.RotationInterval <= 0 {
+		return 0
+	}
+	loc, err := time.LoadLocation(c.Config.RotationTimezone)
+	if err != nil {
+		c.Logger.Err(err.Error())
+		loc = time.Local
+	}
+	now := time.Now()
+	return nextRotation(now, c.Config.RotationInterval, c.Config.RotationAlign, loc).Sub(now)
+}
+
+// armRotationTimer starts the timer for the next time-based rotation, if any
+func (c *Consumer) armRotationTimer(t *time.Timer) {
+	if d := c.rotationDelay(); d > 0 {
+		resetTimer(t, d)
+	} else {
+		t.Stop()
+	}
+}
+
+// armSweepTimer starts the timer for the next retention sweep, if any
+func (c *Consumer) armSweepTimer(t *time.Timer) {
+	if c.Config.SweepIntervalSecs > 0 && c.Config.hasTarget("file") {
+		resetTimer(t, time.Duration(c.Config.SweepIntervalSecs)*time.Second)
+	} else {
+		t.Stop()
+	}
+}
+
 func (c *Consumer) rollOver() error {
 	var err error
-	// Flush writer
-	if err = c.Writer.Flush(); err != nil {
+	// Flush writers
+	if err = c.flush(); err != nil {
 		return err
 	}
 
 	// Do file related stuff only if the target is file
-	if c.Config.Target == "file" {
+	if c.Config.hasTarget("file") {
 		// Close file handle
-		if err = c.currFile.Sync(); err != nil {
+		if _, err = c.tryStage(StageRotation, "syncing the active file", c.currFile.Sync); err != nil {
 			return err
 		}
 		if err = c.currFile.Close(); err != nil {
 			return err
 		}
 
-		var fileName string
-		if fileName, err = c.rename(); err != nil {
+		var pending string
+		renamed, err := c.tryStage(StageRotation, "renaming the active file", func() error {
+			var err error
+			pending, err = c.rename()
 			return err
-		}
-
-		if err = c.compress(fileName); err != nil {
+		})
+		if err != nil {
 			return err
 		}
-
-		if err = c.deleteFiles(); err != nil {
-			return err
+		if !renamed {
+			// Carry on with the same file, it is rotated again once it is full
+			if err = c.appendToFile(); err != nil {
+				return err
+			}
+			c.linesWritten = 0
+			c.bytesWritten = 0
+			return nil
 		}
 
+		// Compressing and removing old files is left to the archiver
+		c.archiver.add(c.Config, pending)
+
+		// Nothing can be written to the file without an active one,
+		// so this is never skipped
 		if err = c.createNewFile(); err != nil {
 			return err
 		}
@@ -196,106 +536,326 @@ func (c *Consumer) rollOver() error {
 	return nil
 }
 
+// rename moves the active file aside, to be archived
 func (c *Consumer) rename() (string, error) {
-	var fileName string
-	var err error
-	if c.Config.FileRenamePolicy == "timestamp" {
-		fileName, err = renameFileTimestamp(c.Config)
-		if err != nil {
-			return "", err
+	pending := pendingName(c.Config, time.Now())
+	err := os.Rename(
+		path.Join(c.Config.DirName, c.Config.ActiveFileName),
+		path.Join(c.Config.DirName, pending),
+	)
+	return pending, err
+}
+
+func (c *Consumer) deleteFiles(cfg *Config) error {
+	n, freed, err := deleteOldFiles(cfg)
+	if n > 0 {
+		c.Logger.Info("removed " + strconv.Itoa(n) + " old files, freed " + strconv.FormatInt(freed, 10) + " bytes")
+	}
+	return err
+}
+
+// enqueue puts the line on the feed. If the feed is full, the line is handled as per
+// the queue policy. It returns false if the consumer has stopped
+func (c *Consumer) enqueue(l inputLine) bool {
+	switch c.queuePolicy {
+	case "drop-newest":
+		select {
+		case c.feed <- l:
+		case <-c.stop:
+			return false
+		default:
+			c.lineDropped()
 		}
-	} else {
-		fileName, err = renameFileSerial(c.Config)
-		if err != nil {
-			return "", err
+		return true
+	case "drop-oldest":
+		for {
+			select {
+			case c.feed <- l:
+				return true
+			case <-c.stop:
+				return false
+			default:
+			}
+			// Make room by dropping the oldest line
+			select {
+			case <-c.feed:
+				c.lineDropped()
+			default:
+			}
 		}
 	}
-	return fileName, nil
+
+	select {
+	case c.feed <- l:
+		return true
+	case <-c.stop:
+		return false
+	}
 }
 
-func (c *Consumer) compress(fileName string) error {
-	// Check config and compress if yes
-	if c.Config.Gzip {
-		err := gzipFile(path.Join(c.Config.DirName, fileName))
-		return err
+// lineDropped counts a line dropped because the feed was full
+func (c *Consumer) lineDropped() {
+	// Warn only when lines start getting dropped, the total is logged at the end
+	if n := c.stats.lineDropped(); n == 1 {
+		c.Logger.Warning("queue is full, dropping lines as per the " + c.queuePolicy + " policy")
 	}
-	return nil
 }
 
-func (c *Consumer) deleteFiles() error {
-	return deleteOldFiles(c.Config)
+// longLine is called by the reader for every line longer than the max line length
+func (c *Consumer) longLine(lr *lineReader) {
+	// Warn only for the first long line, the total is logged at the end
+	if n := c.stats.longLine(); n == 1 {
+		c.Logger.Warning("line longer than " + strconv.Itoa(lr.maxBytes) + " bytes, applying the " +
+			lr.policy + " policy to such lines")
+	}
+}
+
+// processLine filters the line, writes it and rolls over if needed
+func (c *Consumer) processLine(line string) {
+	c.stats.lineProcessed(len(line))
+	// Dropped lines are not counted towards the rollover
+	if c.filter != nil && line != "" {
+		if rule := c.filter.Drop(line); rule != "" {
+			c.stats.lineFiltered(rule)
+			return
+		}
+	}
+	written, err := c.writeLine(line)
+	if err != nil {
+		c.reportError(err)
+	}
+	// Lines dropped by the processor or by every output do not count towards the rollover
+	if !written {
+		return
+	}
+	c.linesWritten++
+	c.bytesWritten += uint64(len(line))
+
+	// Check for rollover
+	if c.rollOverCondition() {
+		if err := c.rollOver(); err != nil {
+			c.reportError(err)
+		}
+	}
+}
+
+// feedLine joins the line to the multi-line event of its stream, if needed,
+// and processes whatever is complete. It returns true if an event is still pending
+func (c *Consumer) feedLine(l inputLine) bool {
+	if c.multiline == nil {
+		c.processLine(tagLine(l.text, l.stream))
+		return false
+	}
+	m := c.events[l.stream]
+	if m == nil {
+		if c.events == nil {
+			c.events = make(map[string]*multilineAssembler)
+		}
+		m = c.multiline.fork()
+		c.events[l.stream] = m
+	}
+	for _, event := range m.add(l.text) {
+		c.processLine(tagLine(event, l.stream))
+	}
+	return m.pending()
+}
+
+// flushMultiline writes the multi-line events being assembled, if any
+func (c *Consumer) flushMultiline() {
+	for stream, m := range c.events {
+		if m.pending() {
+			c.processLine(tagLine(m.flush(), stream))
+		}
+	}
+	c.eventDeadlines = nil
+}
+
+// flushExpiredEvents writes the pending events of the streams
+// which did not get a line till their deadline
+func (c *Consumer) flushExpiredEvents(now time.Time) {
+	for stream, deadline := range c.eventDeadlines {
+		if deadline.After(now) {
+			continue
+		}
+		if m := c.events[stream]; m != nil && m.pending() {
+			c.processLine(tagLine(m.flush(), stream))
+		}
+		delete(c.eventDeadlines, stream)
+	}
+}
+
+// armMultilineTimer starts the timer for the earliest deadline of the pending events, if any
+func (c *Consumer) armMultilineTimer(t *time.Timer) {
+	var earliest time.Time
+	for _, deadline := range c.eventDeadlines {
+		if earliest.IsZero() || deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+	if earliest.IsZero() {
+		t.Stop()
+		return
+	}
+	resetTimer(t, earliest.Sub(time.Now()))
+}
+
+// drainFeed writes the lines still left in the feed
+func (c *Consumer) drainFeed() {
+	for {
+		select {
+		case line := <-c.feed:
+			c.feedLine(line)
+		default:
+			return
+		}
+	}
+}
+
+// resetTimer stops the timer, drains its channel if it had fired,
+// and then starts it again with the new duration
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
 }
 
 func (c *Consumer) startFeed() {
 	// Will flush the writer at some intervals
 	ticker := time.NewTicker(time.Duration(c.Config.FlushingTimeIntervalSecs) * time.Second)
+	// Will write an incomplete multi-line event if no line comes on its stream for some time.
+	// It is started only when an event is pending, for the earliest deadline
+	multilineTimeout := time.Duration(c.Config.MultilineFlushTimeoutMillis) * time.Millisecond
+	multilineTimer := time.NewTimer(multilineTimeout)
+	multilineTimer.Stop()
+	// Will rotate the file at set intervals, even if it is not full
+	rotationTimer := time.NewTimer(time.Hour)
+	c.armRotationTimer(rotationTimer)
+	// Will remove old files at set intervals, even if there is no rotation
+	sweepTimer := time.NewTimer(time.Hour)
+	c.armSweepTimer(sweepTimer)
 	for {
 		select {
-		case line := <-c.feed: // Write to buffered writer
-			err := c.LineProcessor.Write(c.Writer, line)
-			if err != nil {
-				c.errChan <- err
+		case line := <-c.feed: // Write to buffered writers
+			// Wait for the rest of the event till the flush timeout
+			if c.feedLine(line) {
+				if c.eventDeadlines == nil {
+					c.eventDeadlines = make(map[string]time.Time)
+				}
+				c.eventDeadlines[line.stream] = time.Now().Add(multilineTimeout)
+				c.armMultilineTimer(multilineTimer)
+			} else if _, ok := c.eventDeadlines[line.stream]; ok {
+				delete(c.eventDeadlines, line.stream)
+				c.armMultilineTimer(multilineTimer)
 			}
-			// Update counters
-			c.linesWritten++
-			c.bytesWritten += uint64(len(line))
-
-			// Check for rollover
-			if c.rollOverCondition() {
+		case <-multilineTimer.C: // No more lines came for some events, so write what we have
+			c.flushExpiredEvents(time.Now())
+			c.armMultilineTimer(multilineTimer)
+		case <-rotationTimer.C: // Rotation interval is up, rollover if anything was written
+			if c.linesWritten > 0 {
 				if err := c.rollOver(); err != nil {
-					c.errChan <- err
+					c.reportError(err)
 				}
 			}
+			c.armRotationTimer(rotationTimer)
+		case <-sweepTimer.C: // Remove old files
+			if c.archiver != nil {
+				c.archiver.sweepLater(c.Config)
+			}
+			c.armSweepTimer(sweepTimer)
 		case <-c.rolloverChan: // Rollover file to new one
 			if err := c.rollOver(); err != nil {
-				c.errChan <- err
+				c.reportError(err)
 			}
 		case cfg := <-c.ReloadChan: // reload channel to listen to any changes in config file
+			if c.targetsChanged(cfg) {
+				c.Logger.Err("targets other than file can not be added or removed on reload," +
+					" restart funnel to apply the new config")
+				break
+			}
+			c.flushMultiline()
+			multilineTimer.Stop()
 			if err := c.rollOver(); err != nil {
-				c.errChan <- err
+				c.reportError(err)
 			}
 
 			c.LineProcessor = GetLineProcessor(cfg) // setting new line processor
-			if c.Config.Target == "file" {
+			router, err := GetRouter(cfg)
+			if err != nil {
+				c.reportError(err)
+				break
+			}
+			c.router = router // setting new routes
+			filter, err := GetLineFilter(cfg)
+			if err != nil {
+				c.reportError(err)
+				break
+			}
+			c.filter = filter // setting new filter rules
+			multiline, err := newMultilineAssembler(cfg)
+			if err != nil {
+				c.reportError(err)
+				break
+			}
+			c.multiline = multiline // setting new multi-line settings
+			c.events = nil
+			multilineTimeout = time.Duration(cfg.MultilineFlushTimeoutMillis) * time.Millisecond
+			if c.Config.hasTarget("file") {
 				// create new config dir
 				if err := os.MkdirAll(cfg.DirName, 0775); err != nil {
-					c.errChan <- err
+					c.reportError(err)
 					break
 				}
 
 				// close old config file
 				if err := c.currFile.Close(); err != nil {
-					c.errChan <- err
+					c.reportError(err)
 					break
 				}
 
 				// delete old config file
 				if err := os.Remove(path.Join(c.Config.DirName, c.Config.ActiveFileName)); err != nil {
 					if !os.IsNotExist(err) {
-						c.errChan <- err
+						c.reportError(err)
 						break
 					}
 				}
 			}
 			c.Config = cfg // setting new config
+			c.armRotationTimer(rotationTimer)
+			c.armSweepTimer(sweepTimer)
 
-			if c.Config.Target == "file" {
+			if c.Config.hasTarget("file") {
 				// create new config file
-				if err := c.createNewFile(); err != nil {
-					c.errChan <- err
+				if err := c.startActiveFile(); err != nil {
+					c.reportError(err)
+				}
+				if c.archiver == nil {
+					c.archiver = newArchiver(c, c.Config)
 				}
+			} else {
+				c.removeFileOutput()
 			}
+			c.checkRouteOutputs()
 		case <-c.done: // Done signal received, close shop
 			ticker.Stop()
-			if err := c.Writer.Flush(); err != nil {
+			multilineTimer.Stop()
+			rotationTimer.Stop()
+			sweepTimer.Stop()
+			c.drainFeed()
+			c.flushMultiline()
+			if err := c.flush(); err != nil {
 				c.Logger.Err(err.Error())
 			}
 			c.cleanUp()
 			c.wg.Done()
 			return
-		case <-ticker.C: // If tick happens, flush the writer
-			if err := c.Writer.Flush(); err != nil {
-				c.errChan <- err
+		case <-ticker.C: // If tick happens, flush the writers
+			if err := c.flush(); err != nil {
+				c.reportError(err)
 			}
 		}
 	}
@@ -303,18 +863,26 @@ func (c *Consumer) startFeed() {
 
 func (c *Consumer) setupSignalHandling() {
 	c.signalChan = make(chan os.Signal, 1)
-	signal.Notify(c.signalChan,
-		os.Interrupt, syscall.SIGTERM)
+	// SIGUSR1 rotates the file. A supervised app gets the stop signals
+	// instead, and the input streams end when it exits
+	if c.supervised {
+		signal.Notify(c.signalChan, syscall.SIGUSR1)
+	} else {
+		signal.Notify(c.signalChan,
+			os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
+	}
 
 	// Block until a signal is received.
 	go func() {
-		for range c.signalChan {
-			// work is done, signalling done channel
-			c.wg.Add(1)
-			c.done <- struct{}{}
-			c.wg.Wait()
+		for sig := range c.signalChan {
+			if sig == syscall.SIGUSR1 {
+				c.Logger.Info("rotating the active file on SIGUSR1")
+				c.Rotate()
+				continue
+			}
+			c.shutdown()
 			// Everything taken care of, goodbye
-			os.Exit(1)
+			os.Exit(c.ExitCode())
 
 		}
 	}()
@@ -2,13 +2,19 @@ package funnel
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
+	"log/syslog"
 	"math/rand"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRollover(t *testing.T) {
@@ -182,6 +188,448 @@ func TestRolloverSerial(t *testing.T) {
 	}
 }
 
+func TestMultipleTargets(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"file", "recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+
+	f, err := os.Open("testdata/file_84lines")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer f.Close()
+	c.Start(f)
+
+	// The file target should still be rolled over as usual
+	files := readTestDir(t, dir)
+	if len(files) != 3 {
+		t.Errorf("Incorrect no. of files created. Expected 3, Got %d", len(files))
+	}
+	// And the other target should get every line
+	if len(rec.lines) != 84 {
+		t.Errorf("Incorrect no. of lines written to recorder. Expected 84, Got %d", len(rec.lines))
+	}
+	if rec.flushes == 0 {
+		t.Error("Expected recorder to be flushed")
+	}
+	if !rec.closed {
+		t.Error("Expected recorder to be closed")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	errors := &recordingOutput{}
+	c.Config.Targets = []string{"file", "errors"}
+	c.Config.Routes = []RouteConfig{
+		{Match: MatchConfig{Level: "error"}, Outputs: []string{"errors"}},
+	}
+	c.Config.DefaultRoute = []string{"file"}
+	c.Outputs = []*Output{{Name: "errors", Writer: errors}}
+
+	c.Start(strings.NewReader("[INFO] starting\n[ERROR] boom\n[INFO] done\n"))
+
+	if !reflect.DeepEqual(errors.lines, []string{"[ERROR] boom\n"}) {
+		t.Errorf("Incorrect lines routed. Expected only the error line, Got %q", errors.lines)
+	}
+	files := readTestDir(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("Incorrect no. of files created. Expected 1, Got %d", len(files))
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[INFO] starting\n[INFO] done\n" {
+		t.Errorf("Incorrect string found. Expected- %q, Found- %q", "[INFO] starting\n[INFO] done\n", string(data))
+	}
+}
+
+func TestRouteToMissingOutput(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder", "archive"}
+	c.Config.Routes = []RouteConfig{
+		{Match: MatchConfig{Level: "error"}, Outputs: []string{"archive"}},
+	}
+	c.Config.DefaultRoute = []string{"recorder"}
+	// archive is not running, so the lines routed only to it are lost
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+
+	c.Start(strings.NewReader("[INFO] starting\n[ERROR] boom\n"))
+
+	if !reflect.DeepEqual(rec.lines, []string{"[INFO] starting\n"}) {
+		t.Errorf("Incorrect lines routed. Expected only the info line, Got %q", rec.lines)
+	}
+	if n := c.Stats().LinesLost; n != 1 {
+		t.Errorf("Incorrect no. of lines lost. Expected 1, Got %d", n)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.RotationMaxLines = 3
+	c.Config.FilterExclude = []MatchConfig{{Regex: "health"}}
+
+	c.Start(strings.NewReader("line 1\nhealth\nhealth\nline 2\nhealth\nline 3\nline 4\n"))
+
+	// Dropped lines should not count towards rollover
+	files := readTestDir(t, dir)
+	if len(files) != 2 {
+		t.Errorf("Incorrect no. of files created. Expected 2, Got %d", len(files))
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("health")) {
+			t.Errorf("Expected filtered lines to be dropped, Found- %q", string(data))
+		}
+	}
+
+	stats := c.Stats()
+	if n := stats.LinesFiltered["exclude:regex=health"]; n != 3 {
+		t.Errorf("Incorrect no. of filtered lines. Expected 3, Got %d", n)
+	}
+}
+
+func TestFilterProcessorRollover(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.RotationMaxLines = 3
+	lp, err := newFilterProcessor(map[string]interface{}{"exclude": []interface{}{"health"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LineProcessor = lp
+
+	c.Start(strings.NewReader("line 1\nhealth\nhealth\nline 2\nhealth\nline 3\nline 4\n"))
+
+	// Lines dropped by the processor should not count towards rollover either
+	files := readTestDir(t, dir)
+	if len(files) != 2 {
+		t.Errorf("Incorrect no. of files created. Expected 2, Got %d", len(files))
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"file", "recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+	c.Config.RotationMaxLines = 3
+	c.Config.MultilineContinuationPattern = `^\s`
+	c.Config.MultilineMaxLines = 100
+	c.Config.MultilineMaxBytes = 1000
+	c.Config.MultilineFlushTimeoutMillis = 1000
+
+	trace := "panic: boom\n\tgoroutine 1\n\tmain.main()\n"
+	c.Start(strings.NewReader(trace + "after\n"))
+
+	want := []string{trace, "after\n"}
+	if !reflect.DeepEqual(rec.getLines(), want) {
+		t.Errorf("Incorrect events written. Expected %q, Got %q", want, rec.getLines())
+	}
+	// The trace counts as a single line, so the file is not rolled over
+	files := readTestDir(t, dir)
+	if len(files) != 1 {
+		t.Errorf("Incorrect no. of files created. Expected 1, Got %d", len(files))
+	}
+}
+
+func TestMultilineFlushTimeout(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+	c.Config.MultilineContinuationPattern = `^\s`
+	c.Config.MultilineMaxLines = 100
+	c.Config.MultilineMaxBytes = 1000
+	c.Config.MultilineFlushTimeoutMillis = 50
+
+	rdr, wtr := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.Start(rdr)
+		wg.Done()
+	}()
+	wtr.Write([]byte("panic: boom\n\tgoroutine 1\n"))
+	// The event should be written without waiting for the next line
+	time.Sleep(500 * time.Millisecond)
+	want := []string{"panic: boom\n\tgoroutine 1\n"}
+	if !reflect.DeepEqual(rec.getLines(), want) {
+		t.Errorf("Incorrect events written. Expected %q, Got %q", want, rec.getLines())
+	}
+	wtr.Close()
+	wg.Wait()
+}
+
+func TestMultilineFlushTimeoutPerStream(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+	c.Config.MultilineContinuationPattern = `^\s`
+	c.Config.MultilineMaxLines = 100
+	c.Config.MultilineMaxBytes = 1000
+	c.Config.MultilineFlushTimeoutMillis = 100
+
+	rdrA, wtrA := io.Pipe()
+	rdrB, wtrB := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.StartStreams(InputStream{Name: "a", Reader: rdrA}, InputStream{Name: "b", Reader: rdrB})
+		wg.Done()
+	}()
+	wtrA.Write([]byte("panic: boom\n\tgoroutine 1\n"))
+	// Lines on the other stream do not hold back the event of the first one
+	for i := 0; i < 20; i++ {
+		wtrB.Write([]byte("b" + strconv.Itoa(i) + "\n"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	found := false
+	for _, line := range rec.getLines() {
+		found = found || line == "stream=a panic: boom\n\tgoroutine 1\n"
+	}
+	if !found {
+		t.Errorf("Expected the event of stream a to be written, Got %q", rec.getLines())
+	}
+	wtrA.Close()
+	wtrB.Close()
+	wg.Wait()
+}
+
+func TestReloadTargetsChanged(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+	// A new target can not be set up on reload, so the whole config is left out
+	cfg := *c.Config
+	cfg.Targets = []string{"recorder", "kafka"}
+	cfg.PrependValue = "[new]"
+
+	rdr, wtr := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.Start(rdr)
+		wg.Done()
+	}()
+	wtr.Write([]byte("one\n"))
+	c.ReloadChan <- &cfg
+	wtr.Write([]byte("two\n"))
+	wtr.Close()
+	wg.Wait()
+
+	want := []string{"one\n", "two\n"}
+	if !reflect.DeepEqual(rec.getLines(), want) {
+		t.Errorf("Incorrect lines written. Expected %q, Got %q", want, rec.getLines())
+	}
+}
+
+func TestRotationInterval(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.FileRenamePolicy = "serial"
+	c.Config.RotationInterval = 100 * time.Millisecond
+	c.Config.RotationTimezone = "UTC"
+
+	rdr, wtr := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.Start(rdr)
+		wg.Done()
+	}()
+	wtr.Write([]byte("one\n"))
+	// The file is rotated once, a file with nothing written is not rotated again
+	time.Sleep(250 * time.Millisecond)
+	wtr.Write([]byte("two\n"))
+	wtr.Close()
+	wg.Wait()
+
+	files := readTestDir(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("Incorrect no. of files created. Expected 2, Got %d", len(files))
+	}
+	// The active file is rotated on exit too
+	for name, want := range map[string]string{"out.log.2": "one\n", "out.log.1": "two\n"} {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("Incorrect data in %s. Expected %q, Got %q", name, want, data)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.SweepIntervalSecs = 1
+
+	touchOld := func(name string) {
+		fileName := path.Join(dir, name)
+		if err := ioutil.WriteFile(fileName, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(fileName, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(path.Join(dir, name))
+		return err == nil
+	}
+
+	// The files left from before are removed at the start
+	touchOld("out.log.1")
+	rdr, wtr := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.Start(rdr)
+		wg.Done()
+	}()
+	wtr.Write([]byte("one\n"))
+	time.Sleep(100 * time.Millisecond)
+	if exists("out.log.1") {
+		t.Error("Expected the old file to be removed at the start")
+	}
+
+	// And then at every interval, without any rotation
+	touchOld("out.log.2")
+	time.Sleep(1500 * time.Millisecond)
+	if exists("out.log.2") {
+		t.Error("Expected the old file to be removed by the sweep")
+	}
+	wtr.Close()
+	wg.Wait()
+}
+
+func TestLeftoverActiveFile(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   map[string]string
+	}{
+		{"rotate", map[string]string{"out.log.2": "old\n", "out.log.1": "new\n"}},
+		{"append", map[string]string{"out.log.1": "old\nnew\n"}},
+	}
+	for _, test := range tests {
+		dir, c := setupTest(t)
+		c.Config.FileRenamePolicy = "serial"
+		c.Config.LeftoverFilePolicy = test.policy
+		// Left by a funnel which was killed
+		if err := ioutil.WriteFile(path.Join(dir, "out.log"), []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c.Start(strings.NewReader("new\n"))
+
+		files := readTestDir(t, dir)
+		if len(files) != len(test.want) {
+			t.Errorf("Incorrect no. of files for %s policy. Expected %d, Got %d", test.policy, len(test.want), len(files))
+		}
+		for name, want := range test.want {
+			data, err := ioutil.ReadFile(path.Join(dir, name))
+			if err != nil {
+				t.Errorf("Unexpected error for %s policy - %v", test.policy, err)
+				continue
+			}
+			if string(data) != want {
+				t.Errorf("Incorrect data in %s for %s policy. Expected %q, Got %q", name, test.policy, want, data)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+	c.Config.InputMaxLineBytes = 10
+	c.Config.InputLongLinePolicy = "truncate"
+	c.Config.InputTruncateMarker = "..."
+
+	c.Start(strings.NewReader("short\n" + strings.Repeat("x", 100) + "\nshort again\n"))
+
+	want := []string{"short\n", "xxxxxxxxxx...\n", "short agai...\n"}
+	if !reflect.DeepEqual(rec.getLines(), want) {
+		t.Errorf("Incorrect lines written. Expected %q, Got %q", want, rec.getLines())
+	}
+	if n := c.Stats().LongLines; n != 2 {
+		t.Errorf("Incorrect no. of long lines. Expected 2, Got %d", n)
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{"drop-newest", []string{"1\n", "2\n", "3\n"}},
+		{"drop-oldest", []string{"1\n", "9\n", "10\n"}},
+	}
+
+	for _, test := range tests {
+		dir, c := setupTest(t)
+		out := &blockingOutput{started: make(chan struct{}), release: make(chan struct{})}
+		c.Config.Targets = []string{"blocking"}
+		c.Outputs = []*Output{{Name: "blocking", Writer: out}}
+		c.Config.QueueSize = 2
+		c.Config.QueuePolicy = test.policy
+
+		rdr, wtr := io.Pipe()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			c.Start(rdr)
+			wg.Done()
+		}()
+		// The output is stuck on the first line, while the rest come in
+		wtr.Write([]byte("1\n"))
+		<-out.started
+		for i := 2; i <= 10; i++ {
+			wtr.Write([]byte(strconv.Itoa(i) + "\n"))
+		}
+		wtr.Close()
+		for i := 0; c.Stats().LinesDropped < 7; i++ {
+			if i == 100 {
+				t.Fatalf("Lines were not dropped for %s policy", test.policy)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(out.release)
+		wg.Wait()
+		os.RemoveAll(dir)
+
+		if !reflect.DeepEqual(out.getLines(), test.want) {
+			t.Errorf("Incorrect lines written for %s policy. Expected %q, Got %q", test.policy, test.want, out.getLines())
+		}
+		if n := c.Stats().LinesDropped; n != 7 {
+			t.Errorf("Incorrect no. of lines dropped for %s policy. Expected 7, Got %d", test.policy, n)
+		}
+	}
+}
+
 func TestSendInterruptSerial(t *testing.T) {
 	// TODO
 }
@@ -216,7 +664,7 @@ func TestSendInterruptSerial(t *testing.T) {
 		FileRenamePolicy: "serial",
 		MaxAge:           int64(1 * 60 * 60),
 		MaxCount:         500,
-		Target:           "file",
+		Targets:          []string{"file"},
 	}
 
 	wtr.Write([]byte("trying again with a line\n"))
@@ -290,6 +738,16 @@ func BenchmarkFileIO_AppendDSync(b *testing.B) {
 }
 
 // Internal helper functions
+// testLogger returns a syslog writer for the tests. There is no syslog daemon to log to
+// in the test environment, so the logs are sent to a udp port which nobody listens on
+func testLogger(t *testing.T) *syslog.Writer {
+	logger, err := syslog.Dial("udp", "127.0.0.1:514", syslog.LOG_ERR, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
 func setupTest(t *testing.T) (string, *Consumer) {
 	dir, err := ioutil.TempDir("", "test")
 	if err != nil {
@@ -297,6 +755,8 @@ func setupTest(t *testing.T) (string, *Consumer) {
 		return "", nil
 	}
 
+	logger := testLogger(t)
+
 	c := &Consumer{
 		Config: &Config{
 			DirName:                  dir,
@@ -307,10 +767,12 @@ func setupTest(t *testing.T) (string, *Consumer) {
 			FileRenamePolicy:         "timestamp",
 			MaxAge:                   int64(1 * 60 * 60),
 			MaxCount:                 500,
-			Target:                   "file",
+			ArchiveQueueSize:         100,
+			Targets:                  []string{"file"},
 		},
 		LineProcessor: &NoProcessor{},
 		ReloadChan:    make(chan *Config),
+		Logger:        logger,
 	}
 	return dir, c
 }
@@ -324,6 +786,53 @@ func readTestDir(t *testing.T, dir string) []os.FileInfo {
 	return files
 }
 
+// recordingOutput keeps every line written to it
+type recordingOutput struct {
+	mu      sync.Mutex
+	lines   []string
+	flushes int
+	closed  bool
+}
+
+func (r *recordingOutput) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, string(p))
+	return len(p), nil
+}
+
+func (r *recordingOutput) getLines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+func (r *recordingOutput) Flush() error {
+	r.flushes++
+	return nil
+}
+
+func (r *recordingOutput) Close() error {
+	r.closed = true
+	return nil
+}
+
+// blockingOutput blocks the first write till it is released
+type blockingOutput struct {
+	recordingOutput
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingOutput) Write(p []byte) (int, error) {
+	b.once.Do(func() {
+		close(b.started)
+		<-b.release
+	})
+	return b.recordingOutput.Write(p)
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func randStringBytes(n int) []byte {
@@ -0,0 +1,136 @@
+package funnel
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The commands accepted on the control socket
+const (
+	// CommandRotate rotates the active file right away
+	CommandRotate = "rotate"
+)
+
+// controlTimeout is how long a control connection can take to send a command,
+// or to read the reply
+const controlTimeout = 10 * time.Second
+
+// ErrNoControlSocket is raised if a command is sent without a control socket in the config
+var ErrNoControlSocket = errors.New(ControlSocket + " is not set")
+
+// Rotate rotates the active file right away, like SIGUSR1. It does not wait for
+// the rotation, which is skipped if one is pending already. It returns false
+// if the consumer has stopped
+func (c *Consumer) Rotate() bool {
+	select {
+	case <-c.stop:
+		return false
+	default:
+	}
+	select {
+	case c.rolloverChan <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// startControl listens for commands on the control socket, if there is one
+func (c *Consumer) startControl() error {
+	if c.Config.ControlSocket == "" {
+		return nil
+	}
+	// A socket left by a funnel which was killed is in the way
+	if fi, err := os.Lstat(c.Config.ControlSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", c.Config.ControlSocket); err == nil {
+			conn.Close()
+			return errors.New("another funnel is listening on " + c.Config.ControlSocket)
+		}
+		os.Remove(c.Config.ControlSocket)
+	}
+	l, err := net.Listen("unix", c.Config.ControlSocket)
+	if err != nil {
+		return err
+	}
+	c.control = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				// The listener was closed
+				return
+			}
+			go c.handleControl(conn)
+		}
+	}()
+	return nil
+}
+
+// stopControl stops listening for commands
+func (c *Consumer) stopControl() {
+	if c.control != nil {
+		c.control.Close()
+		c.control = nil
+	}
+}
+
+// handleControl runs the command sent on the connection, and replies with ok or the error
+func (c *Consumer) handleControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	reply := "ok"
+	switch cmd := strings.TrimSpace(line); cmd {
+	case CommandRotate:
+		c.Logger.Info("rotating the active file on a control command")
+		if !c.Rotate() {
+			reply = "error: funnel is stopping"
+		}
+	default:
+		reply = "error: unknown command " + cmd
+	}
+	conn.Write([]byte(reply + "\n"))
+}
+
+// SendCommand sends a command to the funnel listening on the control socket
+// of the config, and returns its reply
+func SendCommand(v *viper.Viper, cmd string) (string, error) {
+	setDefaults(v)
+	// Return the error only if config file is present
+	if err := v.ReadInConfig(); err != nil && v.ConfigFileUsed() != "" {
+		return "", err
+	}
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	socket := v.GetString(ControlSocket)
+	if socket == "" {
+		return "", ErrNoControlSocket
+	}
+
+	conn, err := net.DialTimeout("unix", socket, controlTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "error: ") {
+		return "", errors.New(strings.TrimPrefix(reply, "error: "))
+	}
+	return reply, nil
+}
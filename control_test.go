@@ -0,0 +1,106 @@
+package funnel
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// testManualRotation writes a line, rotates the file with the given function and writes
+// another one. Both lines should end up in files of their own
+func testManualRotation(t *testing.T, setup func(c *Consumer), rotate func(c *Consumer)) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.FileRenamePolicy = "serial"
+	setup(c)
+
+	rdr, wtr := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		c.Start(rdr)
+		wg.Done()
+	}()
+	wtr.Write([]byte("one\n"))
+	time.Sleep(100 * time.Millisecond)
+	rotate(c)
+	time.Sleep(100 * time.Millisecond)
+	wtr.Write([]byte("two\n"))
+	wtr.Close()
+	wg.Wait()
+
+	// The active file is rotated on exit too
+	for name, want := range map[string]string{"out.log.2": "one\n", "out.log.1": "two\n"} {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("Incorrect data in %s. Expected %q, Got %q", name, want, data)
+		}
+	}
+}
+
+func TestControlRotate(t *testing.T) {
+	var socket string
+	testManualRotation(t, func(c *Consumer) {
+		socket = path.Join(c.Config.DirName, "funnel.sock")
+		c.Config.ControlSocket = socket
+	}, func(c *Consumer) {
+		v := viper.New()
+		v.Set(ControlSocket, socket)
+		reply, err := SendCommand(v, CommandRotate)
+		if err != nil || reply != "ok" {
+			t.Errorf("Expected ok, Got %q and %v", reply, err)
+		}
+		if _, err := SendCommand(v, "explode"); err == nil {
+			t.Error("Expected an error for an unknown command")
+		}
+	})
+}
+
+func TestSignalRotate(t *testing.T) {
+	testManualRotation(t, func(c *Consumer) {}, func(c *Consumer) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRotateDoesNotBlock(t *testing.T) {
+	c := &Consumer{
+		stop:         make(chan struct{}),
+		rolloverChan: make(chan struct{}, 1),
+	}
+	// Nobody rotates the file, as if the feed was busy writing a line
+	done := make(chan bool)
+	go func() {
+		done <- c.Rotate() && c.Rotate()
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("Expected the rotations to be accepted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Rotate blocked")
+	}
+
+	close(c.stop)
+	if c.Rotate() {
+		t.Error("Expected the rotation to be refused once stopped")
+	}
+}
+
+func TestNoControlSocket(t *testing.T) {
+	if _, err := SendCommand(viper.New(), CommandRotate); err != ErrNoControlSocket {
+		t.Errorf("Expected %v, Got %v", ErrNoControlSocket, err)
+	}
+}
@@ -0,0 +1,152 @@
+package funnel
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// deadLetterRecord is a line of the dead-letter file
+type deadLetterRecord struct {
+	Time   string `json:"time"`
+	Output string `json:"output"`
+	Reason string `json:"reason"`
+	Line   string `json:"line"`
+}
+
+// DeadLetterWriter records the lines which an output rejects in a local file, instead
+// of failing. Every line of the file is a JSON object with the rejected line, the reason
+// and the name of the output. Once the file grows beyond maxBytes, it is rotated by
+// adding a .1 suffix, and older ones are shifted up to keep at most maxFiles of them
+type DeadLetterWriter struct {
+	out      OutputWriter
+	name     string
+	fileName string
+	maxBytes int64
+	maxFiles int
+	logger   *syslog.Writer
+
+	f        *os.File
+	size     int64
+	rejected uint64
+}
+
+// NewDeadLetterWriter returns a DeadLetterWriter around the output, appending to the file
+func NewDeadLetterWriter(out OutputWriter, name, fileName string, maxBytes int64, maxFiles int, logger *syslog.Writer) (*DeadLetterWriter, error) {
+	d := &DeadLetterWriter{
+		out:      out,
+		name:     name,
+		fileName: fileName,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		logger:   logger,
+	}
+	if err := os.MkdirAll(path.Dir(fileName), 0775); err != nil {
+		return nil, err
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DeadLetterWriter) open() error {
+	f, err := os.OpenFile(d.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.f = f
+	d.size = fi.Size()
+	return nil
+}
+
+func (d *DeadLetterWriter) Write(p []byte) (int, error) {
+	n, err := d.out.Write(p)
+	if err = d.handle(err); err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+func (d *DeadLetterWriter) Flush() error {
+	return d.handle(d.out.Flush())
+}
+
+func (d *DeadLetterWriter) Close() error {
+	err := d.out.Close()
+	if d.rejected > 0 {
+		d.logger.Warning("output " + d.name + " rejected " + strconv.FormatUint(d.rejected, 10) +
+			" lines, they are in " + d.fileName)
+	}
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// handle records the rejected lines, if the error is about them.
+// Any other error is returned as is
+func (d *DeadLetterWriter) handle(err error) error {
+	rejErr, ok := err.(*RejectedError)
+	if !ok {
+		return err
+	}
+	for _, l := range rejErr.Lines {
+		if err := d.record(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record appends the rejected line to the file, rotating it if needed
+func (d *DeadLetterWriter) record(l RejectedLine) error {
+	b, err := json.Marshal(deadLetterRecord{
+		Time:   time.Now().Format(time.RFC3339),
+		Output: d.name,
+		Reason: l.Reason,
+		Line:   l.Line,
+	})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if d.size > 0 && d.size+int64(len(b)) > d.maxBytes {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := d.f.Write(b)
+	d.size += int64(n)
+	if err != nil {
+		return err
+	}
+	d.rejected++
+	return nil
+}
+
+// rotate shifts the old files up by one, dropping the oldest one
+func (d *DeadLetterWriter) rotate() error {
+	if err := d.f.Close(); err != nil {
+		return err
+	}
+	for i := d.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(d.fileName+"."+strconv.Itoa(i), d.fileName+"."+strconv.Itoa(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(d.fileName, d.fileName+".1"); err != nil {
+		return err
+	}
+	return d.open()
+}
@@ -0,0 +1,173 @@
+package funnel
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// rejectingOutput rejects the lines which are not JSON when written,
+// and the ones with an "invalid" field when flushed
+type rejectingOutput struct {
+	recordingOutput
+	batch []string
+	err   error
+}
+
+func (r *rejectingOutput) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if !strings.HasPrefix(string(p), "{") {
+		return 0, &RejectedError{Lines: []RejectedLine{{Line: string(p), Reason: "not json"}}}
+	}
+	r.batch = append(r.batch, string(p))
+	return r.recordingOutput.Write(p)
+}
+
+func (r *rejectingOutput) Flush() error {
+	var rejected []RejectedLine
+	for _, line := range r.batch {
+		if strings.Contains(line, "invalid") {
+			rejected = append(rejected, RejectedLine{Line: line, Reason: "mapping failed"})
+		}
+	}
+	r.batch = nil
+	if len(rejected) > 0 {
+		return &RejectedError{Lines: rejected}
+	}
+	return nil
+}
+
+func readDeadLetters(t *testing.T, fileName string) []deadLetterRecord {
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []deadLetterRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &rejectingOutput{}
+	fileName := path.Join(dir, "influxdb.dead")
+	d, err := NewDeadLetterWriter(out, "influxdb", fileName, 1<<20, 2, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"plain\n", `{"ok": 1}` + "\n", `{"invalid": 1}` + "\n"} {
+		if _, err := d.Write([]byte(line)); err != nil {
+			t.Fatalf("Expected rejected lines not to fail the write, Got %v", err)
+		}
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatalf("Expected rejected lines not to fail the flush, Got %v", err)
+	}
+	// Any other error is passed on
+	out.err = errOutputDown
+	if _, err := d.Write([]byte("{}\n")); err != errOutputDown {
+		t.Errorf("Expected %v, Got %v", errOutputDown, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readDeadLetters(t, fileName)
+	if len(records) != 2 {
+		t.Fatalf("Incorrect no. of dead letters. Expected 2, Got %d", len(records))
+	}
+	if records[0].Line != "plain\n" || records[0].Reason != "not json" || records[0].Output != "influxdb" {
+		t.Errorf("Incorrect dead letter. Got %+v", records[0])
+	}
+	if records[1].Line != `{"invalid": 1}`+"\n" || records[1].Reason != "mapping failed" {
+		t.Errorf("Incorrect dead letter. Got %+v", records[1])
+	}
+}
+
+func TestDeadLetterRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := path.Join(dir, "out.dead")
+	// Every record is bigger than the limit, so each one goes to a file of its own
+	d, err := NewDeadLetterWriter(&rejectingOutput{}, "test", fileName, 10, 2, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		d.Write([]byte(line))
+	}
+	d.Close()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Incorrect no. of files. Expected 3, Got %d", len(files))
+	}
+	for suffix, want := range map[string]string{"": "four\n", ".1": "three\n", ".2": "two\n"} {
+		records := readDeadLetters(t, fileName+suffix)
+		if len(records) != 1 || records[0].Line != want {
+			t.Errorf("Incorrect records in %s. Expected %q, Got %+v", fileName+suffix, want, records)
+		}
+	}
+}
+
+func TestDeadLetterFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	RegisterNewWriter("test", newTestOutput)
+	v := viper.New()
+	v.Set(Targets, []interface{}{
+		map[string]interface{}{
+			"name":        "test",
+			"dead_letter": map[string]interface{}{"file": path.Join(dir, "test.dead")},
+			"retry":       map[string]interface{}{},
+		},
+	})
+	outputs, err := GetOutputWriters(v, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := outputs[0].Writer.(*DeadLetterWriter)
+	if !ok {
+		t.Fatalf("Expected a dead-letter writer around the output, Got %T", outputs[0].Writer)
+	}
+	if _, ok := d.out.(*RetryWriter); !ok {
+		t.Errorf("Expected the retries to be inside the dead-letter writer, Got %T", d.out)
+	}
+	if d.maxBytes != defaultDeadLetterMaxBytes || d.maxFiles != defaultDeadLetterMaxFiles {
+		t.Errorf("Incorrect rotation limits. Got %d bytes and %d files", d.maxBytes, d.maxFiles)
+	}
+	d.Close()
+}
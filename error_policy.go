@@ -0,0 +1,158 @@
+package funnel
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The stages of the pipeline which have an error policy of their own
+const (
+	// StageProcessor is the line processor
+	StageProcessor = "processor"
+	// StageOutput is the writing and flushing of the outputs
+	StageOutput = "output"
+	// StageRotation is the renaming, compressing and deleting of files on rollover
+	StageRotation = "rotation"
+)
+
+// The error policies
+const (
+	// PolicyAbort stops funnel
+	PolicyAbort = "abort"
+	// PolicySkipAndLog logs the error and carries on without the failed step
+	PolicySkipAndLog = "skip-and-log"
+	// PolicyRetry tries the failed step again a few times with backoff,
+	// and then carries on like skip-and-log
+	PolicyRetry = "retry"
+)
+
+// errorPolicy returns the policy of the stage
+func errorPolicy(cfg *Config, stage string) string {
+	switch stage {
+	case StageProcessor:
+		return cfg.ErrorsProcessor
+	case StageOutput:
+		return cfg.ErrorsOutput
+	case StageRotation:
+		return cfg.ErrorsRotation
+	}
+	return PolicyAbort
+}
+
+// tryStage runs a step of the stage as per the error policy of the stage.
+// It returns true if the step succeeded. An error is returned only if funnel has to stop
+func (c *Consumer) tryStage(stage, step string, op func() error) (bool, error) {
+	return c.tryStageWith(c.Config, stage, step, op)
+}
+
+// tryStageWith is like tryStage, but with the policies of the given config.
+// It is used outside the feed goroutine, where the config can change on reload
+func (c *Consumer) tryStageWith(cfg *Config, stage, step string, op func() error) (bool, error) {
+	err := op()
+	if err == nil {
+		c.stepSucceeded(stage, step)
+		return true, nil
+	}
+
+	policy := errorPolicy(cfg, stage)
+	if policy == PolicyRetry && !retryable(err) {
+		// Trying again would not help, or would see an empty batch and succeed
+		policy = PolicySkipAndLog
+	}
+	switch policy {
+	case PolicyRetry:
+		backoff := time.Duration(cfg.ErrorsRetryBackoffMillis) * time.Millisecond
+		for i := 1; i < cfg.ErrorsRetryAttempts; i++ {
+			time.Sleep(backoff)
+			backoff *= 2
+			if err = op(); err == nil {
+				c.stepSucceeded(stage, step)
+				return true, nil
+			}
+		}
+		if c.logFailure(stage, step, err) {
+			c.Logger.Err(step + " failed after " + strconv.Itoa(cfg.ErrorsRetryAttempts) +
+				" attempts, skipping it - " + err.Error())
+		}
+	case PolicySkipAndLog:
+		// The spool logs when it starts dropping lines, so they are only counted
+		if err != ErrSpoolFull && c.logFailure(stage, step, err) {
+			c.Logger.Err(step + " failed, skipping it - " + err.Error())
+		}
+	default:
+		return false, err
+	}
+	c.stats.errorSkipped(stage)
+	return false, nil
+}
+
+// retryable checks whether the step can succeed if tried again. Rejected lines
+// are rejected again, a full spool drops the line right away, and an open
+// circuit breaker fails right away till its open period is over
+func retryable(err error) bool {
+	if _, ok := err.(*RejectedError); ok {
+		return false
+	}
+	return err != ErrSpoolFull && err != ErrCircuitOpen
+}
+
+// logFailure checks whether the failure of the step has to be logged. An output
+// which is down fails every line, so such failures are logged once per run.
+// Rejected lines are about the line itself and are always logged
+func (c *Consumer) logFailure(stage, step string, err error) bool {
+	if stage != StageOutput {
+		return true
+	}
+	if _, ok := err.(*RejectedError); ok {
+		return true
+	}
+	return c.failures.failed(step) == 1
+}
+
+// stepSucceeded logs when an output step works again after a run of failures
+func (c *Consumer) stepSucceeded(stage, step string) {
+	if stage != StageOutput {
+		return
+	}
+	if n := c.failures.succeeded(step); n > 0 {
+		c.Logger.Info(step + " works again after " + strconv.Itoa(n) + " failures")
+	}
+}
+
+// failureRuns counts the failures in a row of every step
+type failureRuns struct {
+	mu      sync.Mutex
+	failing map[string]int
+}
+
+// failed records a failure of the step and returns the no. of failures in a row
+func (f *failureRuns) failed(step string) int {
+	f.mu.Lock()
+	if f.failing == nil {
+		f.failing = make(map[string]int)
+	}
+	f.failing[step]++
+	n := f.failing[step]
+	f.mu.Unlock()
+	return n
+}
+
+// succeeded ends the run of failures of the step and returns its length
+func (f *failureRuns) succeeded(step string) int {
+	f.mu.Lock()
+	n := f.failing[step]
+	delete(f.failing, step)
+	f.mu.Unlock()
+	return n
+}
+
+// reportError hands the error over to the main loop, which stops reading.
+// If an error is already waiting there, this one is just logged
+func (c *Consumer) reportError(err error) {
+	select {
+	case c.errChan <- err:
+	default:
+		c.Logger.Err(err.Error())
+	}
+}
@@ -0,0 +1,176 @@
+package funnel
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingProcessor fails for the lines which contain "bad"
+type failingProcessor struct{}
+
+func (failingProcessor) Write(w io.Writer, line string) error {
+	if strings.Contains(line, "bad") {
+		return errors.New("processor failed")
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+func TestProcessorErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    []string
+		skipped uint64
+	}{
+		{PolicySkipAndLog, []string{"one\n", "three\n"}, 1},
+		{PolicyRetry, []string{"one\n", "three\n"}, 1},
+		{PolicyAbort, []string{"one\n"}, 0},
+	}
+
+	for _, test := range tests {
+		dir, c := setupTest(t)
+		rec := &recordingOutput{}
+		c.Config.Targets = []string{"recorder"}
+		c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+		c.LineProcessor = failingProcessor{}
+		c.Config.ErrorsProcessor = test.policy
+		c.Config.ErrorsRetryAttempts = 2
+		c.Config.ErrorsRetryBackoffMillis = 1
+
+		rdr, wtr := io.Pipe()
+		go func() {
+			wtr.Write([]byte("one\nbad\n"))
+			// With abort, funnel stops without waiting for the rest
+			if test.policy != PolicyAbort {
+				wtr.Write([]byte("three\n"))
+				wtr.Close()
+			}
+		}()
+		c.Start(rdr)
+		rdr.Close()
+		os.RemoveAll(dir)
+
+		if !reflect.DeepEqual(rec.getLines(), test.want) {
+			t.Errorf("Incorrect lines written for %s policy. Expected %q, Got %q", test.policy, test.want, rec.getLines())
+		}
+		if n := c.Stats().ErrorsSkipped[StageProcessor]; n != test.skipped {
+			t.Errorf("Incorrect no. of skipped errors for %s policy. Expected %d, Got %d", test.policy, test.skipped, n)
+		}
+	}
+}
+
+func TestOutputErrorPolicy(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	out := &failingOutput{failsLeft: 2}
+	c.Config.Targets = []string{"failing"}
+	c.Outputs = []*Output{{Name: "failing", Writer: out}}
+	c.Config.ErrorsOutput = PolicyRetry
+	c.Config.ErrorsRetryAttempts = 3
+	c.Config.ErrorsRetryBackoffMillis = 1
+
+	c.Start(strings.NewReader("one\ntwo\n"))
+
+	// The first line goes through on the third attempt
+	if out.calls != 4 {
+		t.Errorf("Incorrect no. of writes. Expected 4, Got %d", out.calls)
+	}
+	if n := c.Stats().ErrorsSkipped[StageOutput]; n != 0 {
+		t.Errorf("Expected no errors to be skipped, Got %d", n)
+	}
+}
+
+func TestRejectedNotRetried(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	out := &rejectingOutput{}
+	c.Config.Targets = []string{"rejecting"}
+	c.Outputs = []*Output{{Name: "rejecting", Writer: out}}
+	c.Config.ErrorsOutput = PolicyRetry
+	c.Config.ErrorsRetryAttempts = 3
+	c.Config.ErrorsRetryBackoffMillis = 1000
+
+	// One line is rejected when written, and another when flushed
+	start := time.Now()
+	c.Start(strings.NewReader("plain\n" + `{"a":"invalid"}` + "\n" + `{"a":1}` + "\n"))
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected rejected lines not to be retried, Took %v", d)
+	}
+	s := c.Stats()
+	if s.LinesLost != 2 || s.ErrorsSkipped[StageOutput] != 2 {
+		t.Errorf("Expected 2 lines lost and 2 errors skipped, Got %d and %d", s.LinesLost, s.ErrorsSkipped[StageOutput])
+	}
+	if code := c.ExitCode(); code != ExitLinesLost {
+		t.Errorf("Expected exit code %d, Got %d", ExitLinesLost, code)
+	}
+}
+
+// fullSpoolOutput drops every line like a full spool
+type fullSpoolOutput struct {
+	testOutput
+}
+
+func (*fullSpoolOutput) Write(p []byte) (int, error) {
+	return 0, ErrSpoolFull
+}
+
+func TestSpoolFullLinesLost(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.Config.Targets = []string{"spooled"}
+	c.Outputs = []*Output{{Name: "spooled", Writer: &fullSpoolOutput{}}}
+	c.Config.ErrorsOutput = PolicyRetry
+	c.Config.ErrorsRetryAttempts = 3
+	c.Config.ErrorsRetryBackoffMillis = 1000
+
+	start := time.Now()
+	c.Start(strings.NewReader("one\ntwo\n"))
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected dropped lines not to be retried, Took %v", d)
+	}
+	s := c.Stats()
+	if s.LinesLost != 2 || s.ErrorsSkipped[StageOutput] != 2 {
+		t.Errorf("Expected 2 lines lost and 2 errors skipped, Got %d and %d", s.LinesLost, s.ErrorsSkipped[StageOutput])
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{&RejectedError{Lines: []RejectedLine{{Line: "x\n", Reason: "invalid"}}}, false},
+		{ErrSpoolFull, false},
+		{ErrCircuitOpen, false},
+	}
+	for _, test := range tests {
+		if got := retryable(test.err); got != test.want {
+			t.Errorf("Incorrect result for %v. Expected %v, Got %v", test.err, test.want, got)
+		}
+	}
+}
+
+func TestFailureRuns(t *testing.T) {
+	var f failureRuns
+	for i := 1; i <= 3; i++ {
+		if n := f.failed("writing to output kafka"); n != i {
+			t.Errorf("Incorrect no. of failures in a row. Expected %d, Got %d", i, n)
+		}
+	}
+	if n := f.failed("flushing output kafka"); n != 1 {
+		t.Errorf("Expected steps to be tracked apart, Got %d failures in a row", n)
+	}
+	if n := f.succeeded("writing to output kafka"); n != 3 {
+		t.Errorf("Incorrect length of the run. Expected 3, Got %d", n)
+	}
+	// A new run starts after the step works again
+	if n := f.failed("writing to output kafka"); n != 1 {
+		t.Errorf("Expected a new run of failures, Got %d failures in a row", n)
+	}
+}
@@ -0,0 +1,323 @@
+package funnel
+
+import (
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The templates used if no file name template is set
+const (
+	// The active file name is in the default names, so that funnels sharing
+	// a directory do not take each other's files for their own
+	defaultTimestampTemplate = "{active}.{time:2006-01-02_15-04-05.00000}"
+	defaultSerialTemplate    = "{active}.{seq}"
+	// legacyTimestampTemplate is the default timestamp name of older versions,
+	// which did not have the active file name in it
+	legacyTimestampTemplate = "{time:2006-01-02_15-04-05.00000}.log"
+)
+
+// ErrInvalidFileNameTemplate is raised if the file name template has an unknown
+// placeholder, more than one sequence no. or a path separator
+var ErrInvalidFileNameTemplate = errors.New(FileNameTemplate +
+	" can only have the %Y %y %m %d %H %M %S %j %b %s placeholders and {time:layout}," +
+	" {hostname}, {pid}, {active} and a single {seq}")
+
+// The layouts of the strftime placeholders. %s is the unix time, and is handled separately
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'j': "002",
+	'b': "Jan",
+}
+
+// The kinds of parts of a file name template
+const (
+	partLiteral = iota
+	partTime
+	partUnix
+	partHostname
+	partPid
+	partSeq
+	partActive
+)
+
+// namePart is a literal or a placeholder in a file name template.
+// For time placeholders, text is the layout
+type namePart struct {
+	kind int
+	text string
+}
+
+// parseFileNameTemplate splits the template into literals and placeholders
+func parseFileNameTemplate(tmpl string) ([]namePart, error) {
+	if strings.ContainsAny(tmpl, "/"+string(os.PathSeparator)) {
+		return nil, ErrInvalidFileNameTemplate
+	}
+	var parts []namePart
+	var literal strings.Builder
+	addPart := func(p namePart) {
+		if literal.Len() > 0 {
+			parts = append(parts, namePart{kind: partLiteral, text: literal.String()})
+			literal.Reset()
+		}
+		parts = append(parts, p)
+	}
+
+	seqs := 0
+	for i := 0; i < len(tmpl); i++ {
+		switch tmpl[i] {
+		case '%':
+			if i+1 == len(tmpl) {
+				return nil, ErrInvalidFileNameTemplate
+			}
+			i++
+			code := tmpl[i]
+			if code == '%' {
+				literal.WriteByte('%')
+			} else if code == 's' {
+				addPart(namePart{kind: partUnix})
+			} else if layout, ok := strftimeLayouts[code]; ok {
+				addPart(namePart{kind: partTime, text: layout})
+			} else {
+				return nil, ErrInvalidFileNameTemplate
+			}
+		case '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return nil, ErrInvalidFileNameTemplate
+			}
+			name := tmpl[i+1 : i+end]
+			i += end
+			switch {
+			case name == "hostname":
+				addPart(namePart{kind: partHostname})
+			case name == "pid":
+				addPart(namePart{kind: partPid})
+			case name == "seq":
+				seqs++
+				addPart(namePart{kind: partSeq})
+			case name == "active":
+				addPart(namePart{kind: partActive})
+			case strings.HasPrefix(name, "time:") && len(name) > len("time:"):
+				addPart(namePart{kind: partTime, text: strings.TrimPrefix(name, "time:")})
+			default:
+				return nil, ErrInvalidFileNameTemplate
+			}
+		default:
+			literal.WriteByte(tmpl[i])
+		}
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, namePart{kind: partLiteral, text: literal.String()})
+	}
+	if seqs > 1 || len(parts) == 0 {
+		return nil, ErrInvalidFileNameTemplate
+	}
+	return parts, nil
+}
+
+// fileNamer gives names to the rotated files as per the file name template,
+// and recognises the names given by it. If the template has no {seq},
+// a name which is already taken gets a .N suffix, which is then its sequence no.
+type fileNamer struct {
+	parts    []namePart
+	hasSeq   bool
+	loc      *time.Location
+	active   string
+	hostname string
+	re       *regexp.Regexp
+}
+
+// newFileNamer returns the namer for the template in the config. If it is not set,
+// the default one for serial or timestamp names is used
+func newFileNamer(cfg *Config, serial bool) (*fileNamer, error) {
+	tmpl := cfg.FileNameTemplate
+	loc, err := time.LoadLocation(cfg.RotationTimezone)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == "" {
+		tmpl = defaultTimestampTemplate
+		// Timestamps have always been in UTC
+		loc = time.UTC
+		if serial {
+			tmpl = defaultSerialTemplate
+		}
+	}
+	parts, err := parseFileNameTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &fileNamer{parts: parts, loc: loc, active: cfg.ActiveFileName}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, p := range parts {
+		switch p.kind {
+		case partLiteral:
+			expr.WriteString(regexp.QuoteMeta(p.text))
+		case partTime:
+			expr.WriteString(layoutRegex(p.text))
+		case partUnix, partPid:
+			expr.WriteString("[0-9]+")
+		case partHostname:
+			if n.hostname, err = os.Hostname(); err != nil {
+				return nil, err
+			}
+			expr.WriteString(regexp.QuoteMeta(n.hostname))
+		case partSeq:
+			n.hasSeq = true
+			expr.WriteString("(?P<seq>[0-9]+)")
+		case partActive:
+			expr.WriteString(regexp.QuoteMeta(n.active))
+		}
+	}
+	if !n.hasSeq {
+		expr.WriteString(`(?:\.(?P<seq>[0-9]+))?`)
+	}
+	// The rotated file may have been compressed
+	expr.WriteString("(?:")
+	for i, suffix := range compressedSuffixes {
+		if i > 0 {
+			expr.WriteString("|")
+		}
+		expr.WriteString(regexp.QuoteMeta(suffix))
+	}
+	expr.WriteString(")?$")
+	n.re = regexp.MustCompile(expr.String())
+	return n, nil
+}
+
+// layoutRegex returns a regex matching the times formatted with the layout.
+// Numbers and words in the layout match any number or word
+func layoutRegex(layout string) string {
+	var expr strings.Builder
+	for i := 0; i < len(layout); {
+		j := i
+		switch {
+		case isDigit(layout[i]):
+			for j < len(layout) && isDigit(layout[j]) {
+				j++
+			}
+			expr.WriteString("[0-9]+")
+		case isLetter(layout[i]):
+			for j < len(layout) && isLetter(layout[j]) {
+				j++
+			}
+			expr.WriteString("[A-Za-z]+")
+		default:
+			j++
+			expr.WriteString(regexp.QuoteMeta(layout[i:j]))
+		}
+		i = j
+	}
+	return expr.String()
+}
+
+func isDigit(b byte) bool  { return b >= '0' && b <= '9' }
+func isLetter(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') }
+
+// render returns the name for a file rotated at the given time
+func (n *fileNamer) render(t time.Time, seq int) string {
+	t = t.In(n.loc)
+	var name strings.Builder
+	for _, p := range n.parts {
+		switch p.kind {
+		case partLiteral:
+			name.WriteString(p.text)
+		case partTime:
+			name.WriteString(t.Format(p.text))
+		case partUnix:
+			name.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case partHostname:
+			name.WriteString(n.hostname)
+		case partPid:
+			name.WriteString(strconv.Itoa(os.Getpid()))
+		case partSeq:
+			name.WriteString(strconv.Itoa(seq))
+		case partActive:
+			name.WriteString(n.active)
+		}
+	}
+	if !n.hasSeq && seq > 0 {
+		name.WriteString("." + strconv.Itoa(seq))
+	}
+	return name.String()
+}
+
+// match checks whether the file is a rotated one, and returns its sequence no.
+func (n *fileNamer) match(fileName string) (int, bool) {
+	if fileName == n.active {
+		return 0, false
+	}
+	m := n.re.FindStringSubmatch(fileName)
+	if m == nil {
+		return 0, false
+	}
+	seq, _ := strconv.Atoi(m[1])
+	return seq, true
+}
+
+// withSeq returns the name of a rotated file with its sequence no. changed
+func (n *fileNamer) withSeq(fileName string, seq int) string {
+	loc := n.re.FindStringSubmatchIndex(fileName)
+	if loc == nil || loc[2] < 0 {
+		return fileName
+	}
+	return fileName[:loc[2]] + strconv.Itoa(seq) + fileName[loc[3]:]
+}
+
+// taken checks whether the name is in use, compressed or not
+func (n *fileNamer) taken(dir, fileName string) (bool, error) {
+	for _, suffix := range append([]string{""}, compressedSuffixes...) {
+		_, err := os.Lstat(path.Join(dir, fileName+suffix))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// rotate moves the file to the first name free at the given time, with the suffix
+// added to it. The file is linked to its new name, so that a file which came up
+// in the meantime, like from another funnel, is never overwritten
+func (n *fileNamer) rotate(dir, src string, t time.Time, suffix string) (string, error) {
+	srcPath := path.Join(dir, src)
+	seq := 0
+	if n.hasSeq {
+		seq = 1
+	}
+	for ; ; seq++ {
+		fileName := n.render(t, seq)
+		taken, err := n.taken(dir, fileName)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			continue
+		}
+		fileName += suffix
+		err = os.Link(srcPath, path.Join(dir, fileName))
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			// Hard links are not supported everywhere
+			return fileName, os.Rename(srcPath, path.Join(dir, fileName))
+		}
+		return fileName, os.Remove(srcPath)
+	}
+}
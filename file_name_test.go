@@ -0,0 +1,123 @@
+package funnel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileNameTemplate(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+	now := time.Date(2017, 3, 9, 7, 5, 3, 0, time.UTC)
+	tests := []struct {
+		tmpl string
+		seq  int
+		want string
+	}{
+		{"app-%Y%m%d-%H.log", 0, "app-20170309-07.log"},
+		{"app-%Y%m%d-%H.log", 2, "app-20170309-07.log.2"},
+		{"%y-%j-%b-%M%S-%%.log", 0, "17-068-Mar-0503-%.log"},
+		{"{active}-{time:2006-01-02}.{seq}", 3, "out.log-2017-03-09.3"},
+		{"{hostname}-{pid}-%s.log", 0, hostname + "-" + pid + "-1489043103.log"},
+	}
+	for _, test := range tests {
+		n, err := newFileNamer(&Config{ActiveFileName: "out.log", RotationTimezone: "UTC", FileNameTemplate: test.tmpl}, false)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s - %v", test.tmpl, err)
+		}
+		name := n.render(now, test.seq)
+		if name != test.want {
+			t.Errorf("Incorrect name for %s. Expected %s, Got %s", test.tmpl, test.want, name)
+		}
+		// The names given are recognised, even when compressed
+		for _, fileName := range []string{name, name + ".gz"} {
+			if seq, ok := n.match(fileName); !ok || seq != test.seq {
+				t.Errorf("Expected %s to match %s with sequence no. %d, Got %v and %d", fileName, test.tmpl, test.seq, ok, seq)
+			}
+		}
+		if _, ok := n.match("out.log"); ok {
+			t.Errorf("Expected the active file not to match %s", test.tmpl)
+		}
+	}
+}
+
+func TestInvalidFileNameTemplate(t *testing.T) {
+	for _, tmpl := range []string{"app-%Q.log", "app-{user}.log", "app-{seq}-{seq}.log", "logs/app.log", "app-%", "app-{seq"} {
+		if _, err := parseFileNameTemplate(tmpl); err != ErrInvalidFileNameTemplate {
+			t.Errorf("Expected %v for %s, Got %v", ErrInvalidFileNameTemplate, tmpl, err)
+		}
+	}
+}
+
+func TestFileNameCollision(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+	cfg.FileNameTemplate = "app-%Y%m%d-%H.log"
+
+	// Rotating thrice in the same hour. The compressed file is not overwritten either
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(path.Join(cfg.DirName, cfg.ActiveFileName), []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileName, err := renameFileTimestamp(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if err := gzipFile(path.Join(cfg.DirName, fileName)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var fileNames []string
+	for _, file := range readTestDir(t, cfg.DirName) {
+		fileNames = append(fileNames, file.Name())
+	}
+	sort.Strings(fileNames)
+	name := "app-" + time.Now().UTC().Format("20060102-15") + ".log"
+	want := []string{name, name + ".1.gz", name + ".2"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files. Expected %v, Got %v", want, fileNames)
+	}
+}
+
+func TestRenameFileSerialTemplate(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+	cfg.FileNameTemplate = "app-{seq}-%Y.log"
+	year := time.Now().UTC().Format("2006")
+
+	// Files not matching the template are left alone
+	for _, name := range []string{cfg.ActiveFileName, "app-1-2016.log.gz", "app-2-2016.log", "notes.txt"} {
+		if err := ioutil.WriteFile(path.Join(cfg.DirName, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileName, err := renameFileSerial(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "app-1-" + year + ".log"; fileName != want {
+		t.Errorf("Incorrect file name. Expected %s, Got %s", want, fileName)
+	}
+
+	var fileNames []string
+	for _, file := range readTestDir(t, cfg.DirName) {
+		fileNames = append(fileNames, file.Name())
+	}
+	sort.Strings(fileNames)
+	want := []string{"app-1-" + year + ".log", "app-2-2016.log.gz", "app-3-2016.log", "notes.txt"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files. Expected %v, Got %v", want, fileNames)
+	}
+}
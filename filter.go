@@ -0,0 +1,70 @@
+package funnel
+
+// filterRule is a single include or exclude condition of the filter
+type filterRule struct {
+	name    string
+	matcher *matcher
+}
+
+// LineFilter drops lines before they reach the line processor.
+// If there are include rules, a line has to match at least one of them to be kept.
+// A line matching any of the exclude rules is dropped
+type LineFilter struct {
+	include []*filterRule
+	exclude []*filterRule
+}
+
+// IncludeRuleName is the name under which lines not matching
+// any of the include rules are counted
+const IncludeRuleName = "include"
+
+// GetLineFilter returns the filter for the rules in the config.
+// It returns nil if there are no rules, in which case every line is kept
+func GetLineFilter(cfg *Config) (*LineFilter, error) {
+	if len(cfg.FilterInclude) == 0 && len(cfg.FilterExclude) == 0 {
+		return nil, nil
+	}
+
+	f := &LineFilter{}
+	var err error
+	if f.include, err = getFilterRules(cfg.FilterInclude, "include:"); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = getFilterRules(cfg.FilterExclude, "exclude:"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func getFilterRules(mcs []MatchConfig, prefix string) ([]*filterRule, error) {
+	var rules []*filterRule
+	for _, mc := range mcs {
+		m, err := newMatcher(mc)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &filterRule{name: prefix + mc.String(), matcher: m})
+	}
+	return rules, nil
+}
+
+// Drop returns the name of the rule because of which the line has to be dropped.
+// It returns an empty string if the line has to be kept
+func (f *LineFilter) Drop(line string) string {
+	l := newLogLine(line)
+	for _, rule := range f.exclude {
+		if rule.matcher.match(l) {
+			return rule.name
+		}
+	}
+
+	if len(f.include) == 0 {
+		return ""
+	}
+	for _, rule := range f.include {
+		if rule.matcher.match(l) {
+			return ""
+		}
+	}
+	return IncludeRuleName
+}
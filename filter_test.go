@@ -0,0 +1,49 @@
+package funnel
+
+import "testing"
+
+func TestNoFilter(t *testing.T) {
+	f, err := GetLineFilter(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != nil {
+		t.Errorf("Expected nil filter, Got %v", f)
+	}
+}
+
+func TestFilterDrop(t *testing.T) {
+	cfg := &Config{
+		FilterInclude: []MatchConfig{{Regex: "^GET"}, {Regex: "^POST"}},
+		FilterExclude: []MatchConfig{{Regex: "/health"}, {Field: "path", Value: "/ping"}},
+	}
+	f, err := GetLineFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		rule string
+	}{
+		{"GET /users 200\n", ""},
+		{"POST /users 201\n", ""},
+		{"GET /health 200\n", "exclude:regex=/health"},
+		{"DELETE /users 204\n", IncludeRuleName},
+		{`GET {"path": "/ping"}` + "\n", ""},
+	}
+	for _, test := range tests {
+		if got := f.Drop(test.line); got != test.rule {
+			t.Errorf("Incorrect rule for %q. Expected %q, Got %q", test.line, test.rule, got)
+		}
+	}
+
+	// Field predicates work on JSON lines
+	cfg.FilterInclude = nil
+	if f, err = GetLineFilter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Drop(`{"path": "/ping"}` + "\n"); got != "exclude:path=/ping" {
+		t.Errorf("Incorrect rule for JSON line. Expected %q, Got %q", "exclude:path=/ping", got)
+	}
+}
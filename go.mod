@@ -19,6 +19,7 @@ require (
 	github.com/hpcloud/tail v1.0.0 // indirect
 	github.com/influxdata/influxdb1-client v0.0.0-20190402204710-8ff2fc3824fc
 	github.com/jtolds/gls v4.2.1+incompatible // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/magiconair/properties v1.8.0 // indirect
 	github.com/mailru/easyjson v0.0.0-20180723221831-d5012789d665 // indirect
 	github.com/mitchellh/mapstructure v0.0.0-20180715050151-f15292f7a699 // indirect
@@ -28,7 +29,7 @@ require (
 	github.com/onsi/ginkgo v1.6.0 // indirect
 	github.com/onsi/gomega v1.4.1 // indirect
 	github.com/pelletier/go-toml v1.2.0 // indirect
-	github.com/pierrec/lz4 v2.0.3+incompatible // indirect
+	github.com/pierrec/lz4 v2.0.3+incompatible
 	github.com/pkg/errors v0.8.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20180503174638-e2704e165165 // indirect
@@ -41,6 +42,7 @@ require (
 	github.com/spf13/pflag v1.0.1 // indirect
 	github.com/spf13/viper v1.0.2
 	github.com/stretchr/testify v1.2.2 // indirect
+	github.com/ulikunitz/xz v0.5.11
 	golang.org/x/crypto v0.0.0-20180723164146-c126467f60eb // indirect
 	golang.org/x/net v0.0.0-20180719180050-a680a1efc54d
 	golang.org/x/sync v0.0.0-20180314180146-1d60e4601c6f // indirect
@@ -14,22 +14,107 @@ type LineProcessor interface {
 	Write(io.Writer, string) error
 }
 
+// UnregisteredProcessorError holds the error if some processor type was passed
+// from the config which was not registered
+type UnregisteredProcessorError struct {
+	processor string
+}
+
+func (e *UnregisteredProcessorError) Error() string {
+	return "Processor " + e.processor + " was not registered from any module"
+}
+
+// ProcessorFactory is a function type which holds the line processor registry.
+// It is called with the settings of the processor from the processors list
+type ProcessorFactory func(settings map[string]interface{}) (LineProcessor, error)
+
+var registeredProcessors = make(map[string]ProcessorFactory)
+
+// RegisterNewProcessor is called by the init function of every line processor
+// Adds the constructor to the registry
+func RegisterNewProcessor(name string, factory ProcessorFactory) {
+	registeredProcessors[name] = factory
+}
+
 // GetLineProcessor function returns the particular processor depending
 // on the config.
 func GetLineProcessor(cfg *Config) LineProcessor {
+	if len(cfg.Processors) > 0 {
+		// The config is validated before it reaches here, so the chain can always be built
+		pc, err := NewProcessorChain(cfg)
+		if err != nil {
+			panic(err)
+		}
+		return pc
+	}
+
 	// If no prepend value is needed, return no processor
 	if cfg.PrependValue == "" {
 		return &NoProcessor{}
 	}
 
 	t := template.Must(template.New("line").Parse(cfg.PrependValue))
+	return newPrependProcessor(t, cfg.PrependValue)
+}
 
+func newPrependProcessor(t *template.Template, value string) LineProcessor {
 	// Check if there is a template action in the string
 	// If yes, return the template processor
 	if len(t.Tree.Root.Nodes) > 1 {
 		return &TemplateLineProcessor{template: t}
 	}
-	return &SimpleLineProcessor{prependStr: cfg.PrependValue}
+	return &SimpleLineProcessor{prependStr: value}
+}
+
+// ProcessorChain runs every line through a list of processors, in order.
+// The output of a processor becomes the line for the next one.
+// If a processor does not write anything, the line is dropped
+type ProcessorChain struct {
+	processors []LineProcessor
+	buf        bytes.Buffer
+}
+
+// NewProcessorChain builds the chain from the processors list in the config.
+// If a prepend value is set, it is prepended at the end of the chain
+func NewProcessorChain(cfg *Config) (*ProcessorChain, error) {
+	pc := &ProcessorChain{}
+	for _, settings := range cfg.Processors {
+		name := getString(settings, "type")
+		factory, ok := registeredProcessors[name]
+		if !ok {
+			return nil, &UnregisteredProcessorError{name}
+		}
+		lp, err := factory(settings)
+		if err != nil {
+			return nil, err
+		}
+		pc.processors = append(pc.processors, lp)
+	}
+
+	if cfg.PrependValue != "" {
+		t, err := template.New("line").Parse(cfg.PrependValue)
+		if err != nil {
+			return nil, err
+		}
+		pc.processors = append(pc.processors, newPrependProcessor(t, cfg.PrependValue))
+	}
+	return pc, nil
+}
+
+func (pc *ProcessorChain) Write(w io.Writer, line string) error {
+	for _, lp := range pc.processors {
+		pc.buf.Reset()
+		if err := lp.Write(&pc.buf, line); err != nil {
+			return err
+		}
+		// Dropped by the processor
+		if pc.buf.Len() == 0 {
+			return nil
+		}
+		line = pc.buf.String()
+	}
+	_, err := io.WriteString(w, line)
+	return err
 }
 
 // NoProcessor is used when there is no prepend value.
@@ -38,6 +38,50 @@ func TestGetLineProcessor(t *testing.T) {
 	}
 }
 
+func TestProcessorChain(t *testing.T) {
+	cfg := &Config{
+		Processors: []map[string]interface{}{
+			{"type": "filter", "exclude": []interface{}{"health"}},
+			{"type": "parse-json"},
+			{"type": "add-fields", "fields": map[string]interface{}{"env": "prod"}},
+		},
+		PrependValue: "[app] ",
+	}
+
+	lp := GetLineProcessor(cfg)
+	if _, ok := lp.(*ProcessorChain); !ok {
+		t.Fatalf("Incorrect line processor returned. Expected *funnel.ProcessorChain, Got %s", reflect.TypeOf(lp))
+	}
+
+	var b bytes.Buffer
+	if err := lp.Write(&b, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	want := `[app] {"message":"hello","env":"prod"}` + "\n"
+	if b.String() != want {
+		t.Errorf("Did not match. Expected %q, Got %q", want, b.String())
+	}
+
+	// A line dropped in the middle of the chain is not written at all
+	b.Reset()
+	if err := lp.Write(&b, "GET /health\n"); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Expected line to be dropped, Got %q", b.String())
+	}
+}
+
+func TestUnregisteredProcessor(t *testing.T) {
+	cfg := &Config{
+		Processors: []map[string]interface{}{{"type": "somethingnotthere"}},
+	}
+	_, err := NewProcessorChain(cfg)
+	if _, ok := err.(*UnregisteredProcessorError); !ok {
+		t.Errorf("Expected error to be UnregisteredProcessorError, Got %v", err)
+	}
+}
+
 func TestNoProcessor(t *testing.T) {
 	lp := &NoProcessor{}
 	line := "write this line"
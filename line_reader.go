@@ -0,0 +1,129 @@
+package funnel
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+// lineReader reads lines from the input stream like bufio.Reader.ReadString,
+// but never keeps more than maxBytes of a line in memory. A maxBytes of 0 means no limit.
+// Lines longer than that are dealt with as per the long line policy -
+// truncate - the rest of the line is discarded and the marker is appended
+// split - the line is returned in parts of maxBytes each
+// drop - the whole line is discarded
+type lineReader struct {
+	r        *bufio.Reader
+	maxBytes int
+	policy   string
+	marker   string
+
+	// rest holds what is left of a line being split, along with the error
+	// which came after it, and whether the end of the line has been read
+	rest      []byte
+	restErr   error
+	restDone  bool
+	splitting bool
+	// onLongLine is called once for every line which exceeds maxBytes
+	onLongLine func(lr *lineReader)
+}
+
+func newLineReader(r io.Reader, cfg *Config, onLongLine func(lr *lineReader)) *lineReader {
+	return &lineReader{
+		r:          bufio.NewReader(r),
+		maxBytes:   cfg.InputMaxLineBytes,
+		policy:     cfg.InputLongLinePolicy,
+		marker:     cfg.InputTruncateMarker,
+		onLongLine: onLongLine,
+	}
+}
+
+// readLine returns the next line including the delimiter. If the delimiter is
+// not found, it returns the line along with the error. An empty line is
+// returned if a long line was dropped
+func (lr *lineReader) readLine() (string, error) {
+	line, err, done := lr.rest, lr.restErr, lr.restDone
+	lr.rest, lr.restErr, lr.restDone = nil, nil, false
+	for {
+		// Read more, unless what is left of a split line already has its end
+		if !done {
+			var chunk []byte
+			chunk, err = lr.r.ReadSlice('\n')
+			line = append(line, chunk...)
+			done = err != bufio.ErrBufferFull
+		}
+
+		length := len(line)
+		if length > 0 && line[length-1] == '\n' {
+			length--
+		}
+		if lr.maxBytes > 0 && length > lr.maxBytes {
+			return lr.longLine(line, err, done)
+		}
+		if done {
+			lr.splitting = false
+			return string(line), err
+		}
+	}
+}
+
+// longLine applies the long line policy to a line which has gone over the limit
+func (lr *lineReader) longLine(line []byte, err error, done bool) (string, error) {
+	// A line being split is counted only once
+	if !lr.splitting {
+		lr.onLongLine(lr)
+	}
+	cut := runeBoundary(line, lr.maxBytes)
+
+	switch lr.policy {
+	case "split":
+		// Keep the rest for the next call
+		lr.rest = append([]byte(nil), line[cut:]...)
+		lr.restErr, lr.restDone = err, done
+		lr.splitting = true
+		return string(line[:cut]) + "\n", nil
+	case "drop":
+		if !done {
+			err = lr.discard()
+		}
+		return "", err
+	}
+
+	// truncate
+	if !done {
+		err = lr.discard()
+	}
+	truncated := string(line[:cut]) + lr.marker
+	// The delimiter was found if there was no error
+	if err == nil {
+		truncated += "\n"
+	}
+	return truncated, err
+}
+
+// discard reads and throws away the rest of a line. It returns
+// nil if the delimiter was found
+func (lr *lineReader) discard() error {
+	for {
+		_, err := lr.r.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// runeBoundary returns the largest index not greater than max,
+// which does not fall in the middle of a utf-8 character
+func runeBoundary(b []byte, max int) int {
+	if max >= len(b) {
+		return len(b)
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(b[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		return max
+	}
+	return cut
+}
@@ -0,0 +1,74 @@
+package funnel
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAllLines(t *testing.T, input string, cfg *Config) ([]string, int) {
+	longLines := 0
+	lr := newLineReader(strings.NewReader(input), cfg, func(*lineReader) { longLines++ })
+	var lines []string
+	for {
+		line, err := lr.readLine()
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			return lines, longLines
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLineReaderPolicies(t *testing.T) {
+	long := strings.Repeat("a", 10000)
+	input := "short\n" + long + "\nlast"
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{"truncate", []string{"short\n", strings.Repeat("a", 4000) + "[cut]\n", "last"}},
+		{"split", []string{"short\n", strings.Repeat("a", 4000) + "\n", strings.Repeat("a", 4000) + "\n", strings.Repeat("a", 2000) + "\n", "last"}},
+		{"drop", []string{"short\n", "last"}},
+	}
+
+	for _, test := range tests {
+		lines, longLines := readAllLines(t, input, &Config{
+			InputMaxLineBytes:   4000,
+			InputLongLinePolicy: test.policy,
+			InputTruncateMarker: "[cut]",
+		})
+		if !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("Incorrect lines for %s policy. Got %d lines: %.50q", test.policy, len(lines), lines)
+		}
+		if longLines != 1 {
+			t.Errorf("Incorrect no. of long lines for %s policy. Expected 1, Got %d", test.policy, longLines)
+		}
+	}
+}
+
+func TestLineReaderRuneBoundary(t *testing.T) {
+	// "é" is 2 bytes, so the limit falls in the middle of it
+	lines, _ := readAllLines(t, "abé\n", &Config{
+		InputMaxLineBytes:   3,
+		InputLongLinePolicy: "split",
+	})
+	want := []string{"ab\n", "é\n"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Incorrect lines. Expected %q, Got %q", want, lines)
+	}
+}
+
+func TestLineReaderNoLimit(t *testing.T) {
+	long := strings.Repeat("b", 10000) + "\n"
+	lines, longLines := readAllLines(t, long, &Config{})
+	if len(lines) != 1 || lines[0] != long || longLines != 0 {
+		t.Errorf("Expected the line to be read whole. Got %d lines, %d long lines", len(lines), longLines)
+	}
+}
@@ -0,0 +1,213 @@
+package funnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MatchConfig holds the conditions a line can be matched against.
+// Only the conditions which are set are checked, and all of them must hold
+type MatchConfig struct {
+	// Regex is matched against the whole line
+	Regex string
+	// Field is the dot separated path of a field in a JSON line,
+	// whose value has to be equal to Value
+	Field string
+	Value string
+	// Level is the log level of the line. It is read from the level field
+	// of a JSON line, or searched for as a word in a plain line
+	Level string
+}
+
+// getMatchConfig extracts the match conditions from a table in the config
+func getMatchConfig(m map[string]interface{}) MatchConfig {
+	return MatchConfig{
+		Regex: getString(m, "regex"),
+		Field: getString(m, "field"),
+		Value: getString(m, "value"),
+		Level: getString(m, "level"),
+	}
+}
+
+func (mc MatchConfig) String() string {
+	var conds []string
+	if mc.Regex != "" {
+		conds = append(conds, "regex="+mc.Regex)
+	}
+	if mc.Field != "" {
+		conds = append(conds, mc.Field+"="+mc.Value)
+	}
+	if mc.Level != "" {
+		conds = append(conds, "level="+mc.Level)
+	}
+	return strings.Join(conds, ",")
+}
+
+// levelFields are the fields of a JSON line which are checked for the log level
+var levelFields = []string{"level", "lvl", "severity"}
+
+// matcher is the compiled form of a MatchConfig
+type matcher struct {
+	cfg     MatchConfig
+	regex   *regexp.Regexp
+	levelRe *regexp.Regexp
+}
+
+func newMatcher(mc MatchConfig) (*matcher, error) {
+	m := &matcher{cfg: mc}
+	var err error
+	if mc.Regex != "" {
+		if m.regex, err = regexp.Compile(mc.Regex); err != nil {
+			return nil, err
+		}
+	}
+	if mc.Level != "" {
+		m.levelRe = regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(mc.Level) + `\b`)
+	}
+	return m, nil
+}
+
+// match returns true if the line satisfies all the conditions. A matcher
+// without any conditions matches every line
+func (m *matcher) match(l *logLine) bool {
+	if m.regex != nil && !m.regex.MatchString(l.text) {
+		return false
+	}
+	if m.cfg.Field != "" {
+		val, ok := l.field(m.cfg.Field)
+		if !ok || fieldString(val) != m.cfg.Value {
+			return false
+		}
+	}
+	if m.levelRe != nil {
+		if l.isJSON() {
+			for _, f := range levelFields {
+				if val, ok := l.field(f); ok {
+					return strings.EqualFold(fieldString(val), m.cfg.Level)
+				}
+			}
+			return false
+		}
+		if !m.levelRe.MatchString(l.text) {
+			return false
+		}
+	}
+	return true
+}
+
+// logLine wraps a line read from the input so that it is
+// parsed as JSON at most once, however many matchers look at it
+type logLine struct {
+	text   string
+	parsed bool
+	fields map[string]interface{}
+}
+
+func newLogLine(text string) *logLine {
+	return &logLine{text: text}
+}
+
+// isJSON returns true if the line is a JSON object
+func (l *logLine) isJSON() bool {
+	if !l.parsed {
+		l.parsed = true
+		l.fields, _ = parseJSONObject(l.text)
+	}
+	return l.fields != nil
+}
+
+// field returns the value at the dot separated path in a JSON line
+func (l *logLine) field(path string) (interface{}, bool) {
+	if !l.isJSON() {
+		return nil, false
+	}
+	return lookupField(l.fields, path)
+}
+
+// parseJSONObject returns the fields of the line if it is a JSON object.
+// Numbers are kept as json.Number, so that large integers do not lose precision
+func parseJSONObject(text string) (map[string]interface{}, bool) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, false
+	}
+	var obj map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil || dec.More() {
+		return nil, false
+	}
+	return obj, true
+}
+
+// marshalJSON is like json.Marshal, but leaves <, > and & as they are
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// addJSONFields adds the fields missing from obj, the parsed form of the JSON object
+// in the text, at the end of the object. The rest of the text is kept as it is
+func addJSONFields(text string, obj, fields map[string]interface{}) (string, error) {
+	var keys []string
+	for k := range fields {
+		if _, exists := obj[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return text, nil
+	}
+	sort.Strings(keys)
+
+	end := strings.LastIndexByte(text, '}')
+	var added strings.Builder
+	for i, k := range keys {
+		key, err := marshalJSON(k)
+		if err != nil {
+			return "", err
+		}
+		val, err := marshalJSON(fields[k])
+		if err != nil {
+			return "", err
+		}
+		if i > 0 || len(obj) > 0 {
+			added.WriteByte(',')
+		}
+		added.Write(key)
+		added.WriteByte(':')
+		added.Write(val)
+	}
+	return text[:end] + added.String() + text[end:], nil
+}
+
+func lookupField(fields map[string]interface{}, path string) (interface{}, bool) {
+	var val interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if val, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// fieldString returns the string form of a JSON value
+func fieldString(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return fmt.Sprint(val)
+}
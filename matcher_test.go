@@ -0,0 +1,40 @@
+package funnel
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		cfg   MatchConfig
+		line  string
+		match bool
+	}{
+		{MatchConfig{}, "anything\n", true},
+		{MatchConfig{Regex: "^GET /health"}, "GET /health 200\n", true},
+		{MatchConfig{Regex: "^GET /health"}, "POST /users 201\n", false},
+		{MatchConfig{Field: "status", Value: "500"}, `{"status": 500}` + "\n", true},
+		{MatchConfig{Field: "req.path", Value: "/health"}, `{"req": {"path": "/health"}}` + "\n", true},
+		{MatchConfig{Field: "req.path", Value: "/health"}, `{"req": "/health"}` + "\n", false},
+		{MatchConfig{Field: "status", Value: "500"}, "status 500\n", false},
+		{MatchConfig{Level: "error"}, `{"level": "ERROR", "msg": "boom"}` + "\n", true},
+		{MatchConfig{Level: "error"}, `{"severity": "info", "msg": "error"}` + "\n", false},
+		{MatchConfig{Level: "error"}, "2018-01-01 [ERROR] boom\n", true},
+		{MatchConfig{Level: "error"}, "2018-01-01 [INFO] no errors\n", false},
+		{MatchConfig{Regex: "boom", Level: "error"}, "[ERROR] bang\n", false},
+	}
+
+	for _, test := range tests {
+		m, err := newMatcher(test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.match(newLogLine(test.line)); got != test.match {
+			t.Errorf("Incorrect match for %q with %s. Expected %t, Got %t", test.line, test.cfg, test.match, got)
+		}
+	}
+}
+
+func TestMatcherBadRegex(t *testing.T) {
+	if _, err := newMatcher(MatchConfig{Regex: "(unclosed"}); err == nil {
+		t.Error("Expected error for invalid regex, got none")
+	}
+}
@@ -0,0 +1,93 @@
+package funnel
+
+import (
+	"regexp"
+	"strings"
+)
+
+// multilineAssembler joins the lines of an event spanning multiple lines,
+// like a stack trace, into a single event.
+// A line is joined to the event before it if it matches the continuation pattern,
+// or if a start pattern is set and the line does not match it
+type multilineAssembler struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	maxLines int
+	maxBytes int
+
+	event strings.Builder
+	lines int
+}
+
+// newMultilineAssembler returns nil if neither of the patterns are set
+func newMultilineAssembler(cfg *Config) (*multilineAssembler, error) {
+	if cfg.MultilineStartPattern == "" && cfg.MultilineContinuationPattern == "" {
+		return nil, nil
+	}
+
+	m := &multilineAssembler{
+		maxLines: cfg.MultilineMaxLines,
+		maxBytes: cfg.MultilineMaxBytes,
+	}
+	var err error
+	if cfg.MultilineStartPattern != "" {
+		if m.start, err = regexp.Compile(cfg.MultilineStartPattern); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MultilineContinuationPattern != "" {
+		if m.cont, err = regexp.Compile(cfg.MultilineContinuationPattern); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// fork returns an assembler with the same settings, to assemble
+// the events of another stream
+func (m *multilineAssembler) fork() *multilineAssembler {
+	return &multilineAssembler{
+		start:    m.start,
+		cont:     m.cont,
+		maxLines: m.maxLines,
+		maxBytes: m.maxBytes,
+	}
+}
+
+func (m *multilineAssembler) continues(line string) bool {
+	if m.cont != nil && m.cont.MatchString(line) {
+		return true
+	}
+	return m.start != nil && !m.start.MatchString(line)
+}
+
+// add adds the line to the event being assembled, and returns the
+// events which are complete after that
+func (m *multilineAssembler) add(line string) []string {
+	var events []string
+	// A line which does not continue the event, or would make it
+	// too big, completes the event and begins a new one
+	if m.lines > 0 && (!m.continues(line) || m.event.Len()+len(line) > m.maxBytes) {
+		events = append(events, m.flush())
+	}
+
+	m.event.WriteString(line)
+	m.lines++
+	if m.lines >= m.maxLines || m.event.Len() >= m.maxBytes {
+		events = append(events, m.flush())
+	}
+	return events
+}
+
+// pending returns true if an event is being assembled
+func (m *multilineAssembler) pending() bool {
+	return m.lines > 0
+}
+
+// flush returns the event being assembled, even if it is not complete yet
+func (m *multilineAssembler) flush() string {
+	event := m.event.String()
+	m.event.Reset()
+	m.lines = 0
+	return event
+}
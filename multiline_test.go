@@ -0,0 +1,111 @@
+package funnel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultilineStartPattern(t *testing.T) {
+	m, err := newMultilineAssembler(&Config{
+		MultilineStartPattern: `^\d{4}-\d{2}-\d{2}`,
+		MultilineMaxLines:     100,
+		MultilineMaxBytes:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	for _, line := range []string{
+		"2018-01-01 panic: boom\n",
+		"goroutine 1 [running]:\n",
+		"main.main()\n",
+		"2018-01-01 recovered\n",
+	} {
+		events = append(events, m.add(line)...)
+	}
+	want := []string{"2018-01-01 panic: boom\ngoroutine 1 [running]:\nmain.main()\n"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Incorrect events. Expected %q, Got %q", want, events)
+	}
+	if !m.pending() {
+		t.Fatal("Expected the last line to be pending")
+	}
+	if event := m.flush(); event != "2018-01-01 recovered\n" {
+		t.Errorf("Incorrect pending event. Expected %q, Got %q", "2018-01-01 recovered\n", event)
+	}
+	if m.pending() {
+		t.Error("Expected nothing to be pending after flush")
+	}
+}
+
+func TestMultilineContinuationPattern(t *testing.T) {
+	m, err := newMultilineAssembler(&Config{
+		MultilineContinuationPattern: `^\s+at `,
+		MultilineMaxLines:            100,
+		MultilineMaxBytes:            1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	for _, line := range []string{
+		"Exception in thread main\n",
+		"    at Foo.bar(Foo.java:10)\n",
+		"    at Foo.main(Foo.java:3)\n",
+		"next line\n",
+		"another line\n",
+	} {
+		events = append(events, m.add(line)...)
+	}
+	want := []string{
+		"Exception in thread main\n    at Foo.bar(Foo.java:10)\n    at Foo.main(Foo.java:3)\n",
+		"next line\n",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Incorrect events. Expected %q, Got %q", want, events)
+	}
+}
+
+func TestMultilineLimits(t *testing.T) {
+	m, err := newMultilineAssembler(&Config{
+		MultilineContinuationPattern: `^\s`,
+		MultilineMaxLines:            3,
+		MultilineMaxBytes:            20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Max lines completes the event right away
+	var events []string
+	for _, line := range []string{"a\n", " b\n", " c\n", " d\n"} {
+		events = append(events, m.add(line)...)
+	}
+	want := []string{"a\n b\n c\n"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Incorrect events. Expected %q, Got %q", want, events)
+	}
+	m.flush()
+
+	// A line which would make the event too big begins a new one
+	events = nil
+	for _, line := range []string{"0123456789\n", " 123456789\n"} {
+		events = append(events, m.add(line)...)
+	}
+	want = []string{"0123456789\n"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Incorrect events. Expected %q, Got %q", want, events)
+	}
+}
+
+func TestNoMultiline(t *testing.T) {
+	m, err := newMultilineAssembler(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.Errorf("Expected nil assembler, Got %v", m)
+	}
+}
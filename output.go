@@ -4,6 +4,10 @@ import (
 	"bufio"
 	"io"
 	"log/syslog"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -27,6 +31,67 @@ func (e *UnregisteredOutputError) Error() string {
 	return "Output " + e.target + " was not registered from any module"
 }
 
+// OutputError holds the error returned by a particular output target
+type OutputError struct {
+	Target string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return "Output " + e.Target + " failed - " + e.Err.Error()
+}
+
+// OutputErrors holds the errors returned by more than one output target
+// during the same operation
+type OutputErrors []*OutputError
+
+func (e OutputErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// combineOutputErrors returns nil if there are no errors, the error itself if there
+// is only one and an OutputErrors otherwise
+func combineOutputErrors(errs []*OutputError) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return OutputErrors(errs)
+}
+
+// RejectedLine is a line which an output can never accept, along with the reason
+type RejectedLine struct {
+	Line   string
+	Reason string
+}
+
+// RejectedError is returned by an output when it rejects lines for good, like a line
+// which is not valid JSON. Trying to write them again would not help, so they are
+// not retried, and go to the dead-letter file of the output if there is one
+type RejectedError struct {
+	Lines []RejectedLine
+}
+
+func (e *RejectedError) Error() string {
+	if len(e.Lines) == 1 {
+		return "line rejected - " + e.Lines[0].Reason
+	}
+	return strconv.Itoa(len(e.Lines)) + " lines rejected - " + e.Lines[0].Reason
+}
+
+// Output is a target along with the writer which writes to it.
+// For the file target, the writer is set by the consumer when it creates the active file
+type Output struct {
+	Name   string
+	Writer OutputWriter
+}
+
 // OutputFactory is a function type which holds the output registry
 type OutputFactory func(v *viper.Viper, logger *syslog.Writer) (OutputWriter, error)
 
@@ -52,6 +117,119 @@ func GetOutputWriter(v *viper.Viper, logger *syslog.Writer) (OutputWriter, error
 	return w(v, logger)
 }
 
+// GetOutputWriters returns an output for every target in the targets list.
+// If there is no list, the single target from the target section is returned
+func GetOutputWriters(v *viper.Viper, logger *syslog.Writer) ([]*Output, error) {
+	if !v.IsSet(Targets) {
+		w, err := GetOutputWriter(v, logger)
+		if err != nil {
+			return nil, err
+		}
+		if w, err = wrapOutputWriter(v, w, logger); err != nil {
+			return nil, err
+		}
+		return []*Output{{Name: v.GetString(Target), Writer: w}}, nil
+	}
+
+	var outputs []*Output
+	for _, target := range getTargetConfigs(v) {
+		// Every output reads its settings from the target section,
+		// so each entry gets a viper instance of its own
+		sub := viper.New()
+		sub.Set("target", target)
+		w, err := GetOutputWriter(sub, logger)
+		if err == nil {
+			w, err = wrapOutputWriter(sub, w, logger)
+		}
+		if err != nil {
+			// Close whatever has been created till now
+			for _, o := range outputs {
+				if o.Writer != nil {
+					o.Writer.Close()
+				}
+			}
+			return nil, err
+		}
+		outputs = append(outputs, &Output{Name: sub.GetString(Target), Writer: w})
+	}
+	return outputs, nil
+}
+
+// getRetryConfig reads the retry settings of the target section. Unset settings get defaults
+func getRetryConfig(v *viper.Viper) RetryConfig {
+	getInt := func(key string, def int) int {
+		if v.IsSet(key) {
+			return v.GetInt(key)
+		}
+		return def
+	}
+	return RetryConfig{
+		Attempts:        getInt(RetryAttempts, 3),
+		InitialBackoff:  time.Duration(getInt(RetryInitialBackoffMillis, 100)) * time.Millisecond,
+		MaxBackoff:      time.Duration(getInt(RetryMaxBackoffMillis, 5000)) * time.Millisecond,
+		BreakerFailures: getInt(RetryBreakerFailures, 5),
+		BreakerOpen:     time.Duration(getInt(RetryBreakerOpenSecs, 30)) * time.Second,
+	}
+}
+
+const (
+	// defaultSpoolMaxBytes is the size limit of a spool if none is set
+	defaultSpoolMaxBytes = 1 << 30 // 1GB
+	// defaultDeadLetterMaxBytes and defaultDeadLetterMaxFiles are the
+	// rotation limits of a dead-letter file if none are set
+	defaultDeadLetterMaxBytes = 10 << 20 // 10MB
+	defaultDeadLetterMaxFiles = 5
+)
+
+// wrapOutputWriter puts the wrappers which the target section opts
+// into around the output writer
+func wrapOutputWriter(v *viper.Viper, w OutputWriter, logger *syslog.Writer) (OutputWriter, error) {
+	// The file target is written by the consumer itself
+	if w == nil {
+		return nil, nil
+	}
+	name := v.GetString(Target)
+
+	// Retries are closest to the output, so that the spool sees only
+	// the errors which remain after retrying
+	if v.Get(Retry) != nil {
+		w = NewRetryWriter(w, name, getRetryConfig(v), logger)
+	}
+
+	// Rejected lines are set aside before they reach the spool
+	if fileName := v.GetString(DeadLetterFile); fileName != "" {
+		maxBytes := int64(defaultDeadLetterMaxBytes)
+		if v.IsSet(DeadLetterMaxBytes) {
+			maxBytes = v.GetInt64(DeadLetterMaxBytes)
+		}
+		maxFiles := defaultDeadLetterMaxFiles
+		if v.IsSet(DeadLetterMaxFiles) {
+			maxFiles = v.GetInt(DeadLetterMaxFiles)
+		}
+		dl, err := NewDeadLetterWriter(w, name, fileName, maxBytes, maxFiles, logger)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = dl
+	}
+
+	if dir := v.GetString(SpoolDirectory); dir != "" {
+		maxBytes := int64(defaultSpoolMaxBytes)
+		if v.IsSet(SpoolMaxBytes) {
+			maxBytes = v.GetInt64(SpoolMaxBytes)
+		}
+		// Every output gets a spool of its own
+		spool, err := NewSpoolWriter(w, name, path.Join(dir, name), maxBytes, logger)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = spool
+	}
+	return w, nil
+}
+
 // FileOutput is just an embed type which adds the Close method to buffered writer to satisfy the OutputWriter interface
 // XXX: Might need to implement this in a better way
 type FileOutput struct {
@@ -60,6 +60,32 @@ func TestRegisteredOutputWriter(t *testing.T) {
 	}
 }
 
+// get multiple outputs from the targets list
+func TestOutputWriters(t *testing.T) {
+	RegisterNewWriter("test", newTestOutput)
+	v := viper.New()
+	v.Set(Targets, []interface{}{
+		map[string]interface{}{"name": "file"},
+		map[string]interface{}{"name": "test"},
+	})
+
+	logger, _ := syslog.New(syslog.LOG_ERR, "test")
+
+	outputs, err := GetOutputWriters(v, logger)
+	if err != nil {
+		t.Fatalf("Expected nil error, Got %s", err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("Incorrect no. of outputs. Expected 2, Got %d", len(outputs))
+	}
+	if outputs[0].Name != "file" || outputs[0].Writer != nil {
+		t.Errorf("Expected file output with nil writer, Got %s with %v", outputs[0].Name, outputs[0].Writer)
+	}
+	if _, ok := outputs[1].Writer.(*testOutput); outputs[1].Name != "test" || !ok {
+		t.Errorf("Expected test output with testOutput writer, Got %s with %v", outputs[1].Name, outputs[1].Writer)
+	}
+}
+
 // Dummy function and struct types to test out the output registration
 func newTestOutput(v *viper.Viper, logger *syslog.Writer) (OutputWriter, error) {
 	return &testOutput{}, nil
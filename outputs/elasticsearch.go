@@ -56,6 +56,9 @@ type elasticOutput struct {
 	index     string
 	indexType string
 	logger    *syslog.Writer
+	// docs holds the documents of the bulk request in order, to
+	// find the ones which were rejected from the response
+	docs []string
 }
 
 // Implmenting the OutputWriter interface
@@ -67,13 +70,38 @@ func (e *elasticOutput) Write(p []byte) (n int, err error) {
 		Index(e.index).
 		Type(e.indexType)
 	e.bulkSvc.Add(bulkReq)
+	e.docs = append(e.docs, string(p))
 	return len(p), nil
 }
 
 func (e *elasticOutput) Flush() error {
+	// Nothing to send
+	if e.bulkSvc.NumberOfActions() == 0 {
+		return nil
+	}
 	// Sends all bulked request to elasticsearch
-	_, err := e.bulkSvc.Do(context.TODO())
-	return err
+	resp, err := e.bulkSvc.Do(context.TODO())
+	if err != nil {
+		return err
+	}
+	// The request went through, but some documents may have been rejected
+	docs := e.docs
+	e.docs = nil
+	var rejectedLines []funnel.RejectedLine
+	for i, item := range resp.Items {
+		for _, res := range item {
+			if res.Error != nil && i < len(docs) {
+				rejectedLines = append(rejectedLines, funnel.RejectedLine{
+					Line:   docs[i],
+					Reason: res.Error.Type + ": " + res.Error.Reason,
+				})
+			}
+		}
+	}
+	if len(rejectedLines) > 0 {
+		return &funnel.RejectedError{Lines: rejectedLines}
+	}
+	return nil
 }
 
 func (e *elasticOutput) Close() error {
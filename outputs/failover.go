@@ -0,0 +1,302 @@
+package outputs
+
+// This is the failover output writer. It is a chain of other outputs
+import (
+	"bufio"
+	"errors"
+	"log/syslog"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/agnivade/funnel"
+	"github.com/spf13/viper"
+)
+
+// Registering the constructor function
+func init() {
+	funnel.RegisterNewWriter("failover", newFailoverOutput)
+}
+
+var (
+	// ErrNoFailoverOutputs is raised if the failover target does not list any outputs
+	ErrNoFailoverOutputs = errors.New("failover target must have a list of outputs")
+	// ErrNoFailoverFilePath is raised if a file output in the failover chain does not have a path
+	ErrNoFailoverFilePath = errors.New("file output in a failover chain must have a path")
+)
+
+func newFailoverOutput(v *viper.Viper, logger *syslog.Writer) (funnel.OutputWriter, error) {
+	f := &failoverOutput{
+		switchAfter:   3,
+		failbackAfter: time.Minute,
+		maxPending:    10000,
+		logger:        logger,
+		now:           time.Now,
+	}
+	if v.IsSet("target.switch_after_errors") {
+		f.switchAfter = v.GetInt("target.switch_after_errors")
+	}
+	if v.IsSet("target.failback_interval_secs") {
+		f.failbackAfter = time.Duration(v.GetInt("target.failback_interval_secs")) * time.Second
+	}
+	if v.IsSet("target.max_pending_lines") {
+		f.maxPending = v.GetInt("target.max_pending_lines")
+	}
+
+	for _, entry := range funnel.GetTables(v.Get("target.outputs")) {
+		sub := viper.New()
+		sub.Set("target", entry)
+		w, err := newFailoverMember(sub, logger)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.members = append(f.members, &funnel.Output{Name: sub.GetString("target.name"), Writer: w})
+	}
+	if len(f.members) == 0 {
+		return nil, ErrNoFailoverOutputs
+	}
+	return f, nil
+}
+
+// newFailoverMember returns the writer of an output in the chain. The file target
+// is written by the consumer, so the chain has a simple file writer of its own
+func newFailoverMember(v *viper.Viper, logger *syslog.Writer) (funnel.OutputWriter, error) {
+	if v.GetString("target.name") != "file" {
+		return funnel.GetOutputWriter(v, logger)
+	}
+	fileName := v.GetString("target.path")
+	if fileName == "" {
+		return nil, ErrNoFailoverFilePath
+	}
+	if err := os.MkdirAll(path.Dir(fileName), 0775); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &appendFileOutput{bufio.NewWriter(file), file}, nil
+}
+
+// failoverOutput writes to the first healthy output of an ordered list.
+// Once the active output fails switchAfter times in a row, it switches to the next one.
+// While it is not on the first output, it tries to go back to it every failbackAfter.
+// The lines written since the last flush are kept, and written again to the output
+// switched to, so that lines buffered in a failed output are not lost. They may be
+// delivered twice if the failed output had sent them already. Only the last
+// maxPending lines are kept, the older ones are lost if the output fails
+type failoverOutput struct {
+	members       []*funnel.Output
+	active        int
+	errors        int
+	switchedAt    time.Time
+	switchAfter   int
+	failbackAfter time.Duration
+	maxPending    int
+	logger        *syslog.Writer
+
+	// pending holds the lines written since the last successful flush
+	pending [][]byte
+	// dropped is the no. of lines written since the last successful flush
+	// which were dropped from pending to keep it within maxPending
+	dropped int
+	// unsent is the no. of pending lines, at the end, which the active output has not got yet
+	unsent int
+
+	// replaced in tests
+	now func() time.Time
+}
+
+// Implementing the OutputWriter interface
+
+func (f *failoverOutput) Write(p []byte) (int, error) {
+	rejected := f.failback()
+	for {
+		r, err := f.catchUp()
+		rejected = append(rejected, r...)
+		n := 0
+		if err == nil {
+			n, err = f.members[f.active].Writer.Write(p)
+		}
+		if err == nil {
+			f.errors = 0
+			f.keep(p)
+			return n, rejectedError(rejected)
+		}
+		// The output is working, it just does not accept the line
+		if rejErr, ok := err.(*funnel.RejectedError); ok {
+			return n, rejectedError(append(rejected, rejErr.Lines...))
+		}
+		// Try the line again on the next output, if there was a switch
+		if !f.failed(err) {
+			f.logRejected(rejected)
+			return n, err
+		}
+	}
+}
+
+func (f *failoverOutput) Flush() error {
+	var rejected []funnel.RejectedLine
+	for {
+		r, err := f.catchUp()
+		rejected = append(rejected, r...)
+		if err == nil {
+			err = f.members[f.active].Writer.Flush()
+		}
+		if err == nil {
+			f.errors = 0
+			f.clearPending()
+			return rejectedError(rejected)
+		}
+		// The output is working, and has sent the lines it accepted
+		if rejErr, ok := err.(*funnel.RejectedError); ok {
+			f.clearPending()
+			return rejectedError(append(rejected, rejErr.Lines...))
+		}
+		// The pending lines are written to the next output, if there was a switch
+		if !f.failed(err) {
+			f.logRejected(rejected)
+			return err
+		}
+	}
+}
+
+func (f *failoverOutput) Close() error {
+	var err error
+	for _, m := range f.members {
+		if cerr := m.Writer.Close(); cerr != nil {
+			f.logger.Err((&funnel.OutputError{Target: m.Name, Err: cerr}).Error())
+			err = cerr
+		}
+	}
+	return err
+}
+
+// failed counts the error of the active output, and switches to the next one if it
+// has failed too many times. It returns true if there was a switch. A full round
+// of switches without any success is not repeated, so that a write is never stuck
+func (f *failoverOutput) failed(err error) bool {
+	f.errors++
+	if f.errors < f.switchAfter || len(f.members) == 1 {
+		return false
+	}
+	next := (f.active + 1) % len(f.members)
+	f.logger.Err("output " + f.members[f.active].Name + " failed " + strconv.Itoa(f.errors) +
+		" times, switching to " + f.members[next].Name + " - " + err.Error())
+	f.switchTo(next)
+	// Every output has had its turn
+	return next != 0
+}
+
+// keep adds the line written to the active output to the pending lines.
+// The oldest line is dropped once there are more than maxPending
+func (f *failoverOutput) keep(p []byte) {
+	f.pending = append(f.pending, append([]byte(nil), p...))
+	if len(f.pending) <= f.maxPending {
+		return
+	}
+	f.pending = f.pending[1:]
+	f.dropped++
+	if f.dropped == 1 {
+		f.logger.Warning("failover output keeps only the last " + strconv.Itoa(f.maxPending) +
+			" lines written since the last flush, older ones are lost if the output fails")
+	}
+}
+
+// clearPending forgets the pending lines once the active output has sent them
+func (f *failoverOutput) clearPending() {
+	f.pending = nil
+	f.dropped = 0
+}
+
+// switchTo makes the output active. It gets all the pending lines before any new one
+func (f *failoverOutput) switchTo(i int) {
+	if f.dropped > 0 {
+		f.logger.Err(strconv.Itoa(f.dropped) + " lines written to output " + f.members[f.active].Name +
+			" since the last flush were not kept, they are not written to " + f.members[i].Name)
+	}
+	f.active = i
+	f.errors = 0
+	f.switchedAt = f.now()
+	f.unsent = len(f.pending)
+}
+
+// catchUp writes the pending lines which the active output has not got yet.
+// The lines it rejects are left out, and returned
+func (f *failoverOutput) catchUp() ([]funnel.RejectedLine, error) {
+	var rejected []funnel.RejectedLine
+	for f.unsent > 0 {
+		i := len(f.pending) - f.unsent
+		_, err := f.members[f.active].Writer.Write(f.pending[i])
+		if rejErr, ok := err.(*funnel.RejectedError); ok {
+			rejected = append(rejected, rejErr.Lines...)
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			f.unsent--
+			continue
+		}
+		if err != nil {
+			return rejected, err
+		}
+		f.unsent--
+	}
+	return rejected, nil
+}
+
+// failback goes back to the first output if it has been a while since the switch.
+// A single error sends it back to the next output. It returns the lines rejected
+// by the current output while flushing it
+func (f *failoverOutput) failback() []funnel.RejectedLine {
+	if f.active == 0 || f.now().Sub(f.switchedAt) < f.failbackAfter {
+		return nil
+	}
+	// The buffered lines of the current output are sent before switching.
+	// If that fails, they are written to the first output as well
+	rejected, err := f.catchUp()
+	if err == nil {
+		err = f.members[f.active].Writer.Flush()
+	}
+	if rejErr, ok := err.(*funnel.RejectedError); ok {
+		rejected = append(rejected, rejErr.Lines...)
+		err = nil
+	}
+	if err == nil {
+		f.clearPending()
+	} else {
+		f.logger.Err((&funnel.OutputError{Target: f.members[f.active].Name, Err: err}).Error())
+	}
+	f.logger.Info("trying to fail back to output " + f.members[0].Name)
+	f.switchTo(0)
+	f.errors = f.switchAfter - 1
+	return rejected
+}
+
+// logRejected logs the rejected lines which could not be returned along with another error
+func (f *failoverOutput) logRejected(rejected []funnel.RejectedLine) {
+	if len(rejected) > 0 {
+		f.logger.Err((&funnel.RejectedError{Lines: rejected}).Error())
+	}
+}
+
+// rejectedError returns the error for the rejected lines, if there are any
+func rejectedError(rejected []funnel.RejectedLine) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	return &funnel.RejectedError{Lines: rejected}
+}
+
+// appendFileOutput appends the lines to a file
+type appendFileOutput struct {
+	*bufio.Writer
+	file *os.File
+}
+
+func (a *appendFileOutput) Close() error {
+	if err := a.Flush(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
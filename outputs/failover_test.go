@@ -0,0 +1,233 @@
+package outputs
+
+import (
+	"errors"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agnivade/funnel"
+	"github.com/spf13/viper"
+)
+
+var errDown = errors.New("output is down")
+
+// fakeOutput records the lines, and fails while it is down
+type fakeOutput struct {
+	lines []string
+	down  bool
+}
+
+func (f *fakeOutput) Write(p []byte) (int, error) {
+	if f.down {
+		return 0, errDown
+	}
+	f.lines = append(f.lines, string(p))
+	return len(p), nil
+}
+
+func (f *fakeOutput) Flush() error {
+	if f.down {
+		return errDown
+	}
+	return nil
+}
+
+func (f *fakeOutput) Close() error {
+	return nil
+}
+
+func setupFailover(t *testing.T, settings map[string]interface{}) (*failoverOutput, *fakeOutput, *fakeOutput) {
+	primary, secondary := &fakeOutput{}, &fakeOutput{}
+	funnel.RegisterNewWriter("fake-primary", func(*viper.Viper, *syslog.Writer) (funnel.OutputWriter, error) {
+		return primary, nil
+	})
+	funnel.RegisterNewWriter("fake-secondary", func(*viper.Viper, *syslog.Writer) (funnel.OutputWriter, error) {
+		return secondary, nil
+	})
+
+	settings["name"] = "failover"
+	settings["outputs"] = []interface{}{
+		map[string]interface{}{"name": "fake-primary"},
+		map[string]interface{}{"name": "fake-secondary"},
+	}
+	v := viper.New()
+	v.Set("target", settings)
+	// There is no syslog daemon to log to in the test environment,
+	// so the logs are sent to a udp port which nobody listens on
+	logger, err := syslog.Dial("udp", "127.0.0.1:514", syslog.LOG_ERR, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := funnel.GetOutputWriter(v, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.(*failoverOutput), primary, secondary
+}
+
+func TestFailover(t *testing.T) {
+	f, primary, secondary := setupFailover(t, map[string]interface{}{
+		"switch_after_errors":    2,
+		"failback_interval_secs": 60,
+	})
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	f.Write([]byte("one\n"))
+	primary.down = true
+	// The first error is returned, the second one switches over
+	if _, err := f.Write([]byte("two\n")); err != errDown {
+		t.Fatalf("Expected %v, Got %v", errDown, err)
+	}
+	if _, err := f.Write([]byte("two\n")); err != nil {
+		t.Fatalf("Expected the line to go to the secondary output, Got %v", err)
+	}
+	f.Write([]byte("three\n"))
+
+	// Before the failback interval, lines stay on the secondary output
+	primary.down = false
+	now = now.Add(30 * time.Second)
+	f.Write([]byte("four\n"))
+	// After it, the primary output gets them again
+	now = now.Add(30 * time.Second)
+	f.Write([]byte("five\n"))
+
+	if got := len(primary.lines); got != 2 || primary.lines[1] != "five\n" {
+		t.Errorf("Incorrect lines in primary output. Got %q", primary.lines)
+	}
+	// The line not flushed by the primary output is written to the secondary one too
+	if got := len(secondary.lines); got != 4 || secondary.lines[0] != "one\n" {
+		t.Errorf("Incorrect lines in secondary output. Got %q", secondary.lines)
+	}
+}
+
+func TestFailoverFlush(t *testing.T) {
+	f, primary, secondary := setupFailover(t, map[string]interface{}{
+		"switch_after_errors":    2,
+		"failback_interval_secs": 60,
+	})
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	f.Write([]byte("one\n"))
+	f.Write([]byte("two\n"))
+	// The flush fails, and the lines buffered in the primary output go to the secondary one
+	primary.down = true
+	if err := f.Flush(); err != errDown {
+		t.Fatalf("Expected %v, Got %v", errDown, err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatalf("Expected the lines to be flushed to the secondary output, Got %v", err)
+	}
+	if len(secondary.lines) != 2 || secondary.lines[1] != "two\n" {
+		t.Errorf("Incorrect lines in secondary output. Got %q", secondary.lines)
+	}
+
+	// If the secondary output can not be flushed on failback, the lines go to the primary one
+	f.Write([]byte("three\n"))
+	primary.down = false
+	secondary.down = true
+	now = now.Add(time.Minute)
+	f.Write([]byte("four\n"))
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one\n", "two\n", "three\n", "four\n"}
+	if !reflect.DeepEqual(primary.lines, want) {
+		t.Errorf("Incorrect lines in primary output. Expected %q, Got %q", want, primary.lines)
+	}
+}
+
+func TestFailoverMaxPending(t *testing.T) {
+	f, primary, secondary := setupFailover(t, map[string]interface{}{
+		"switch_after_errors": 2,
+		"max_pending_lines":   2,
+	})
+
+	f.Write([]byte("one\n"))
+	f.Write([]byte("two\n"))
+	f.Write([]byte("three\n"))
+	// Only the last two lines are written again to the secondary output
+	primary.down = true
+	f.Flush()
+	if err := f.Flush(); err != nil {
+		t.Fatalf("Expected the lines to be flushed to the secondary output, Got %v", err)
+	}
+	want := []string{"two\n", "three\n"}
+	if !reflect.DeepEqual(secondary.lines, want) {
+		t.Errorf("Incorrect lines in secondary output. Expected %q, Got %q", want, secondary.lines)
+	}
+	if f.dropped != 0 || len(f.pending) != 0 {
+		t.Errorf("Expected pending lines to be cleared after the flush, Got %d pending and %d dropped", len(f.pending), f.dropped)
+	}
+}
+
+func TestFailbackFailure(t *testing.T) {
+	f, primary, secondary := setupFailover(t, map[string]interface{}{
+		"switch_after_errors":    3,
+		"failback_interval_secs": 60,
+	})
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	primary.down = true
+	for i := 0; i < 3; i++ {
+		f.Write([]byte("line\n"))
+	}
+	if f.active != 1 {
+		t.Fatal("Expected a switch to the secondary output")
+	}
+	// A failed attempt to fail back switches over right away
+	now = now.Add(time.Minute)
+	if _, err := f.Write([]byte("line\n")); err != nil {
+		t.Fatalf("Expected the line to go to the secondary output, Got %v", err)
+	}
+	if f.active != 1 || len(secondary.lines) != 2 {
+		t.Errorf("Expected the secondary output to stay active. Got %d lines", len(secondary.lines))
+	}
+}
+
+func TestFailoverFileMember(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := path.Join(dir, "fallback", "out.log")
+	v := viper.New()
+	v.Set("target", map[string]interface{}{
+		"name": "failover",
+		"outputs": []interface{}{
+			map[string]interface{}{"name": "file", "path": fileName},
+		},
+	})
+	w, err := funnel.GetOutputWriter(v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("Incorrect file contents. Expected %q, Got %q", "hello\n", data)
+	}
+}
+
+func TestFailoverNoOutputs(t *testing.T) {
+	v := viper.New()
+	v.Set("target", map[string]interface{}{"name": "failover"})
+	if _, err := funnel.GetOutputWriter(v, nil); err != ErrNoFailoverOutputs {
+		t.Errorf("Expected %v, Got %v", ErrNoFailoverOutputs, err)
+	}
+}
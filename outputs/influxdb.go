@@ -80,12 +80,12 @@ func (i *influxDBOutput) Write(p []byte) (int, error) {
 	line := influxDBLine{}
 	err := json.Unmarshal(p, &line)
 	if err != nil {
-		return 0, err
+		return 0, rejected(p, err)
 	}
 	// Constructing the new point
 	pt, err := influxdb.NewPoint(i.metric, line.Tags, line.Fields, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, rejected(p, err)
 	}
 	// Adding to the batch
 	i.batchPts.AddPoint(pt)
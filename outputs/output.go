@@ -1,3 +1,10 @@
 package outputs
 
-// Empty, required to ensure build of the funnel/outputs package.
+import "github.com/agnivade/funnel"
+
+// rejected returns the error for a line which the output can never accept
+func rejected(p []byte, err error) error {
+	return &funnel.RejectedError{
+		Lines: []funnel.RejectedLine{{Line: string(p), Reason: err.Error()}},
+	}
+}
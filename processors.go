@@ -0,0 +1,136 @@
+package funnel
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Registering the built-in line processors
+func init() {
+	RegisterNewProcessor("prepend", newPrependFromSettings)
+	RegisterNewProcessor("redact", newRedactProcessor)
+	RegisterNewProcessor("parse-json", newParseJSONProcessor)
+	RegisterNewProcessor("add-fields", newAddFieldsProcessor)
+	RegisterNewProcessor("filter", newFilterProcessor)
+}
+
+var (
+	// ErrNoPrependValue is raised if a prepend processor does not have a value
+	ErrNoPrependValue = errors.New("prepend processor must have a value")
+	// ErrNoFields is raised if an add-fields processor does not have any fields to add
+	ErrNoFields = errors.New("add-fields processor must have a table of fields")
+	// ErrNoFilterRules is raised if a filter processor does not have any rules
+	ErrNoFilterRules = errors.New("filter processor must have include or exclude rules")
+)
+
+// newPrependFromSettings prepends the value to every line. The value
+// can contain the same template actions as misc.prepend_value
+func newPrependFromSettings(settings map[string]interface{}) (LineProcessor, error) {
+	value := getString(settings, "value")
+	if value == "" {
+		return nil, ErrNoPrependValue
+	}
+	t, err := template.New("line").Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return newPrependProcessor(t, value), nil
+}
+
+// ParseJSONProcessor makes sure every line is a JSON object.
+// JSON lines are passed as is, and any other line is wrapped in an
+// object with the line as the value of the message field
+type ParseJSONProcessor struct {
+	field string
+}
+
+func newParseJSONProcessor(settings map[string]interface{}) (LineProcessor, error) {
+	pp := &ParseJSONProcessor{field: getString(settings, "field")}
+	if pp.field == "" {
+		pp.field = "message"
+	}
+	return pp, nil
+}
+
+func (pp *ParseJSONProcessor) Write(w io.Writer, line string) error {
+	text, newline := splitNewline(line)
+	if _, ok := parseJSONObject(text); ok {
+		_, err := io.WriteString(w, line)
+		return err
+	}
+	b, err := marshalJSON(map[string]string{pp.field: text})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, string(b)+newline)
+	return err
+}
+
+// AddFieldsProcessor adds a fixed set of fields to the end of every JSON line.
+// Fields already present in the line are not overwritten, and the rest of the line is kept as it is.
+// Lines which are not JSON objects are passed as is
+type AddFieldsProcessor struct {
+	fields map[string]interface{}
+}
+
+func newAddFieldsProcessor(settings map[string]interface{}) (LineProcessor, error) {
+	fields, ok := settings["fields"].(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, ErrNoFields
+	}
+	return &AddFieldsProcessor{fields: fields}, nil
+}
+
+func (ap *AddFieldsProcessor) Write(w io.Writer, line string) error {
+	text, newline := splitNewline(line)
+	obj, ok := parseJSONObject(text)
+	if !ok {
+		_, err := io.WriteString(w, line)
+		return err
+	}
+	text, err := addJSONFields(text, obj, ap.fields)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, text+newline)
+	return err
+}
+
+// FilterProcessor drops the lines which do not pass its include and exclude rules.
+// The rules are the same as the ones in the filter section
+type FilterProcessor struct {
+	filter *LineFilter
+}
+
+func newFilterProcessor(settings map[string]interface{}) (LineProcessor, error) {
+	cfg := &Config{
+		FilterInclude: getMatchConfigs(settings["include"]),
+		FilterExclude: getMatchConfigs(settings["exclude"]),
+	}
+	f, err := GetLineFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrNoFilterRules
+	}
+	return &FilterProcessor{filter: f}, nil
+}
+
+func (fp *FilterProcessor) Write(w io.Writer, line string) error {
+	if fp.filter.Drop(line) != "" {
+		return nil
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// splitNewline separates the trailing newline from the line
+func splitNewline(line string) (string, string) {
+	if strings.HasSuffix(line, "\n") {
+		return line[:len(line)-1], "\n"
+	}
+	return line, ""
+}
@@ -0,0 +1,93 @@
+package funnel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func runProcessor(t *testing.T, settings map[string]interface{}, line string) string {
+	factory, ok := registeredProcessors[getString(settings, "type")]
+	if !ok {
+		t.Fatalf("Processor %s not registered", getString(settings, "type"))
+	}
+	lp, err := factory(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := lp.Write(&b, line); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestPrependFromSettings(t *testing.T) {
+	got := runProcessor(t, map[string]interface{}{"type": "prepend", "value": "[app] "}, "hello\n")
+	if got != "[app] hello\n" {
+		t.Errorf("Did not match. Expected %q, Got %q", "[app] hello\n", got)
+	}
+
+	if _, err := newPrependFromSettings(map[string]interface{}{}); err != ErrNoPrependValue {
+		t.Errorf("Expected ErrNoPrependValue, Got %v", err)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	settings := map[string]interface{}{"type": "parse-json"}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"msg": "hi"}` + "\n", `{"msg": "hi"}` + "\n"},
+		{"plain \"quoted\" line\n", `{"message":"plain \"quoted\" line"}` + "\n"},
+		{"no newline", `{"message":"no newline"}`},
+	}
+	for _, test := range tests {
+		if got := runProcessor(t, settings, test.line); got != test.want {
+			t.Errorf("Did not match. Expected %q, Got %q", test.want, got)
+		}
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	settings := map[string]interface{}{
+		"type":   "add-fields",
+		"fields": map[string]interface{}{"env": "prod", "app": "api"},
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"app": "web", "msg": "hi"}` + "\n", `{"app": "web", "msg": "hi","env":"prod"}` + "\n"},
+		// Numbers and escapes in the line are not changed
+		{`{"id":9007199254740993,"msg":"a<b"}`, `{"id":9007199254740993,"msg":"a<b","app":"api","env":"prod"}`},
+		{`{}`, `{"app":"api","env":"prod"}`},
+	}
+	for _, test := range tests {
+		if got := runProcessor(t, settings, test.line); got != test.want {
+			t.Errorf("Did not match. Expected %q, Got %q", test.want, got)
+		}
+	}
+
+	// Plain lines are passed as is
+	if got := runProcessor(t, settings, "plain\n"); got != "plain\n" {
+		t.Errorf("Did not match. Expected %q, Got %q", "plain\n", got)
+	}
+
+	if _, err := newAddFieldsProcessor(map[string]interface{}{}); err != ErrNoFields {
+		t.Errorf("Expected ErrNoFields, Got %v", err)
+	}
+}
+
+func TestFilterProcessor(t *testing.T) {
+	settings := map[string]interface{}{
+		"type":    "filter",
+		"exclude": []interface{}{"health"},
+	}
+	if got := runProcessor(t, settings, "GET /health\n"); got != "" {
+		t.Errorf("Expected line to be dropped, Got %q", got)
+	}
+	if got := runProcessor(t, settings, "GET /users\n"); got != "GET /users\n" {
+		t.Errorf("Did not match. Expected %q, Got %q", "GET /users\n", got)
+	}
+}
@@ -0,0 +1,252 @@
+package funnel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidRedactMode is raised for invalid values to the mode of a redact processor
+	ErrInvalidRedactMode = errors.New("redact processor mode can only be mask, hash or drop-field")
+	// ErrNoHashKey is raised if a redact processor in hash mode does not have a key
+	ErrNoHashKey = errors.New("redact processor must have a hash_key in hash mode")
+)
+
+// UnknownDetectorError is raised if a redact processor lists a built-in detector which does not exist
+type UnknownDetectorError struct {
+	Detector string
+}
+
+func (e *UnknownDetectorError) Error() string {
+	return "Unknown redact detector " + e.Detector
+}
+
+// detector finds one kind of secret in a line. If the regex has
+// a capture group, only the group is considered to be the secret
+type detector struct {
+	regex *regexp.Regexp
+	// valid, if set, weeds out matches which look like the secret but are not
+	valid func(string) bool
+}
+
+// builtinDetectors are the secrets a redact processor knows about
+var builtinDetectors = map[string]*detector{
+	"credit_card": {
+		regex: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid: luhnValid,
+	},
+	"email": {
+		regex: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"bearer_token": {
+		regex: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	},
+	"aws_key": {
+		regex: regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+	},
+	"aws_secret": {
+		regex: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`),
+	},
+}
+
+// RedactProcessor hides secrets and personal data in a line before it is written anywhere.
+// Depending on the mode, every secret is
+// mask - replaced with a fixed string
+// hash - replaced with its keyed HMAC, so that equal values can still be correlated
+// drop-field - removed along with its field in a JSON line. Other lines are masked
+type RedactProcessor struct {
+	detectors   []*detector
+	mode        string
+	replacement string
+	hashKey     []byte
+	dropFields  map[string]bool
+}
+
+func newRedactProcessor(settings map[string]interface{}) (LineProcessor, error) {
+	rp := &RedactProcessor{
+		mode:        getString(settings, "mode"),
+		replacement: getString(settings, "replacement"),
+		hashKey:     []byte(getString(settings, "hash_key")),
+		dropFields:  make(map[string]bool),
+	}
+	if rp.mode == "" {
+		rp.mode = "mask"
+	}
+	if rp.mode != "mask" && rp.mode != "hash" && rp.mode != "drop-field" {
+		return nil, ErrInvalidRedactMode
+	}
+	if rp.mode == "hash" && len(rp.hashKey) == 0 {
+		return nil, ErrNoHashKey
+	}
+	if rp.replacement == "" {
+		rp.replacement = "[REDACTED]"
+	}
+	for _, f := range getStringSlice(settings, "fields") {
+		rp.dropFields[f] = true
+	}
+
+	// All the built-in detectors are used unless a list is given
+	names := getStringSlice(settings, "builtin")
+	if _, ok := settings["builtin"]; !ok {
+		for name := range builtinDetectors {
+			names = append(names, name)
+		}
+		// Keep the order stable, as one detector works on the output of another
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		d, ok := builtinDetectors[name]
+		if !ok {
+			return nil, &UnknownDetectorError{name}
+		}
+		rp.detectors = append(rp.detectors, d)
+	}
+
+	for _, p := range getStringSlice(settings, "patterns") {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		rp.detectors = append(rp.detectors, &detector{regex: re})
+	}
+	return rp, nil
+}
+
+func (rp *RedactProcessor) Write(w io.Writer, line string) error {
+	if rp.mode == "drop-field" {
+		text, newline := splitNewline(line)
+		if obj, ok := parseJSONObject(text); ok {
+			rp.dropSecretFields(obj)
+			b, err := marshalJSON(obj)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, string(b)+newline)
+			return err
+		}
+	}
+
+	for _, d := range rp.detectors {
+		line = d.replace(line, rp.redact)
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// redact returns what a secret is replaced with
+func (rp *RedactProcessor) redact(secret string) string {
+	if rp.mode != "hash" {
+		return rp.replacement
+	}
+	mac := hmac.New(sha256.New, rp.hashKey)
+	mac.Write([]byte(secret))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// dropSecretFields removes the listed fields and the fields containing
+// a secret from the object, and from any object nested inside it
+func (rp *RedactProcessor) dropSecretFields(obj map[string]interface{}) {
+	for k, v := range obj {
+		if rp.dropFields[k] || rp.hasSecret(v) {
+			delete(obj, k)
+		}
+	}
+}
+
+// hasSecret checks whether the value is a secret, or is an array with a secret
+// anywhere in it. Objects inside the value have their secret fields removed instead
+func (rp *RedactProcessor) hasSecret(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case map[string]interface{}:
+		rp.dropSecretFields(val)
+		return false
+	case []interface{}:
+		for _, elem := range val {
+			if rp.hasSecret(elem) {
+				return true
+			}
+		}
+		return false
+	default:
+		// Numbers like card numbers are checked too
+		return rp.containsSecret(fieldString(val))
+	}
+}
+
+func (rp *RedactProcessor) containsSecret(s string) bool {
+	for _, d := range rp.detectors {
+		if len(d.secrets(s)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// secrets returns the start and end of every valid secret in the line
+func (d *detector) secrets(line string) [][2]int {
+	var found [][2]int
+	for _, m := range d.regex.FindAllStringSubmatchIndex(line, -1) {
+		start, end := m[0], m[1]
+		// Only the first capture group is the secret, if there is one
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if d.valid != nil && !d.valid(line[start:end]) {
+			continue
+		}
+		found = append(found, [2]int{start, end})
+	}
+	return found
+}
+
+// replace returns the line with every secret replaced by the output of fn
+func (d *detector) replace(line string, fn func(string) string) string {
+	found := d.secrets(line)
+	if len(found) == 0 {
+		return line
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, f := range found {
+		start, end := f[0], f[1]
+		b.WriteString(line[last:start])
+		b.WriteString(fn(line[start:end]))
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// luhnValid checks the digits of a card number with the Luhn algorithm,
+// ignoring any spaces and dashes in between
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		n := int(c - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
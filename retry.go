@@ -0,0 +1,153 @@
+package funnel
+
+import (
+	"errors"
+	"log/syslog"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the output while its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuit breaker states
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// RetryConfig holds the settings of a RetryWriter
+type RetryConfig struct {
+	// Attempts is the max no. of times an operation is tried
+	Attempts int
+	// The wait before the next attempt doubles every time, from InitialBackoff till MaxBackoff.
+	// A random jitter of up to half of the wait is taken off
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// The circuit breaker opens after BreakerFailures operations fail in a row,
+	// and stays open for BreakerOpen
+	BreakerFailures int
+	BreakerOpen     time.Duration
+}
+
+// RetryWriter retries the failed writes and flushes of an output with backoff.
+// If the output keeps failing, a circuit breaker opens and calls fail right away
+// without reaching the output. Once the open period is over, a single call is let
+// through as a probe. The circuit closes if it succeeds, and opens again otherwise.
+// Rejected lines are not retried
+type RetryWriter struct {
+	out    OutputWriter
+	name   string
+	cfg    RetryConfig
+	logger *syslog.Writer
+
+	state    int
+	failures int
+	openedAt time.Time
+
+	// replaced in tests
+	sleep func(time.Duration)
+	now   func() time.Time
+}
+
+// NewRetryWriter returns a RetryWriter around the output
+func NewRetryWriter(out OutputWriter, name string, cfg RetryConfig, logger *syslog.Writer) *RetryWriter {
+	return &RetryWriter{
+		out:    out,
+		name:   name,
+		cfg:    cfg,
+		logger: logger,
+		sleep:  time.Sleep,
+		now:    time.Now,
+	}
+}
+
+func (r *RetryWriter) Write(p []byte) (int, error) {
+	var n int
+	err := r.do(func() error {
+		var err error
+		n, err = r.out.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (r *RetryWriter) Flush() error {
+	return r.do(r.out.Flush)
+}
+
+// Close is not retried, the output is closed only once
+func (r *RetryWriter) Close() error {
+	return r.out.Close()
+}
+
+// do runs the operation as per the state of the circuit breaker
+func (r *RetryWriter) do(op func() error) error {
+	attempts := r.cfg.Attempts
+	switch r.state {
+	case circuitOpen:
+		if r.now().Sub(r.openedAt) < r.cfg.BreakerOpen {
+			return ErrCircuitOpen
+		}
+		r.state = circuitHalfOpen
+		fallthrough
+	case circuitHalfOpen:
+		// Only a single try to probe whether the output has recovered
+		attempts = 1
+	}
+
+	var err error
+	backoff := r.cfg.InitialBackoff
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			r.sleep(jitter(backoff))
+			backoff *= 2
+			if backoff > r.cfg.MaxBackoff {
+				backoff = r.cfg.MaxBackoff
+			}
+		}
+		err = op()
+		if err == nil {
+			r.succeeded()
+			return nil
+		}
+		// The output is working, it just does not accept these lines
+		if _, ok := err.(*RejectedError); ok {
+			r.succeeded()
+			return err
+		}
+	}
+	r.failed(err)
+	return err
+}
+
+func (r *RetryWriter) succeeded() {
+	if r.state != circuitClosed {
+		r.logger.Info("circuit breaker of output " + r.name + " closed, the output has recovered")
+	}
+	r.state = circuitClosed
+	r.failures = 0
+}
+
+func (r *RetryWriter) failed(err error) {
+	r.failures++
+	if r.state == circuitHalfOpen || r.failures >= r.cfg.BreakerFailures {
+		if r.state != circuitOpen {
+			r.logger.Err("circuit breaker of output " + r.name + " opened after " +
+				strconv.Itoa(r.failures) + " failures - " + err.Error())
+		}
+		r.state = circuitOpen
+		r.openedAt = r.now()
+	}
+}
+
+// jitter returns a random duration between half of d and d
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
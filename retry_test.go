@@ -0,0 +1,139 @@
+package funnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// failingOutput fails the given no. of calls, and counts all of them
+type failingOutput struct {
+	testOutput
+	failsLeft int
+	calls     int
+}
+
+func (f *failingOutput) Write(p []byte) (int, error) {
+	f.calls++
+	if f.failsLeft > 0 {
+		f.failsLeft--
+		return 0, errOutputDown
+	}
+	return len(p), nil
+}
+
+func newTestRetryWriter(t *testing.T, out OutputWriter) (*RetryWriter, *[]time.Duration, *time.Time) {
+	r := NewRetryWriter(out, "test", RetryConfig{
+		Attempts:        3,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      150 * time.Millisecond,
+		BreakerFailures: 2,
+		BreakerOpen:     time.Minute,
+	}, testLogger(t))
+	var sleeps []time.Duration
+	now := time.Now()
+	r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	r.now = func() time.Time { return now }
+	return r, &sleeps, &now
+}
+
+func TestRetryBackoff(t *testing.T) {
+	out := &failingOutput{failsLeft: 2}
+	r, sleeps, _ := newTestRetryWriter(t, out)
+
+	if _, err := r.Write([]byte("line\n")); err != nil {
+		t.Fatalf("Expected the write to succeed on the last attempt, Got %v", err)
+	}
+	if out.calls != 3 {
+		t.Errorf("Incorrect no. of attempts. Expected 3, Got %d", out.calls)
+	}
+	if len(*sleeps) != 2 {
+		t.Fatalf("Incorrect no. of waits. Expected 2, Got %d", len(*sleeps))
+	}
+	// The second wait is doubled, but capped at the max backoff
+	bounds := [][2]time.Duration{{50 * time.Millisecond, 100 * time.Millisecond}, {75 * time.Millisecond, 150 * time.Millisecond}}
+	for i, d := range *sleeps {
+		if d < bounds[i][0] || d > bounds[i][1] {
+			t.Errorf("Wait %d out of bounds. Expected between %v and %v, Got %v", i, bounds[i][0], bounds[i][1], d)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	out := &failingOutput{failsLeft: 1000}
+	r, _, now := newTestRetryWriter(t, out)
+
+	// Two failed writes open the circuit
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("line\n")); err != errOutputDown {
+			t.Fatalf("Expected %v, Got %v", errOutputDown, err)
+		}
+	}
+	calls := out.calls
+	if _, err := r.Write([]byte("line\n")); err != ErrCircuitOpen {
+		t.Fatalf("Expected %v, Got %v", ErrCircuitOpen, err)
+	}
+	if out.calls != calls {
+		t.Error("Expected the output not to be called while the circuit is open")
+	}
+
+	// A failed probe opens the circuit again
+	*now = now.Add(time.Minute)
+	if _, err := r.Write([]byte("line\n")); err != errOutputDown {
+		t.Fatalf("Expected %v, Got %v", errOutputDown, err)
+	}
+	if out.calls != calls+1 {
+		t.Errorf("Expected a single probe. Got %d calls", out.calls-calls)
+	}
+	if _, err := r.Write([]byte("line\n")); err != ErrCircuitOpen {
+		t.Fatalf("Expected %v, Got %v", ErrCircuitOpen, err)
+	}
+
+	// A successful probe closes it
+	out.failsLeft = 0
+	*now = now.Add(time.Minute)
+	if _, err := r.Write([]byte("line\n")); err != nil {
+		t.Fatalf("Expected the probe to succeed, Got %v", err)
+	}
+	if r.state != circuitClosed {
+		t.Error("Expected the circuit to be closed")
+	}
+}
+
+func TestRetryRejectedLines(t *testing.T) {
+	r, sleeps, _ := newTestRetryWriter(t, &rejectingOutput{})
+	for i := 0; i < 3; i++ {
+		if _, err := r.Write([]byte("plain\n")); err == nil {
+			t.Fatal("Expected the rejected error to be returned")
+		}
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("Expected rejected lines not to be retried, Got %d waits", len(*sleeps))
+	}
+	if r.state != circuitClosed {
+		t.Error("Expected rejected lines not to open the circuit")
+	}
+}
+
+func TestRetryFromConfig(t *testing.T) {
+	RegisterNewWriter("test", newTestOutput)
+	v := viper.New()
+	v.Set(Targets, []interface{}{
+		map[string]interface{}{
+			"name":  "test",
+			"retry": map[string]interface{}{"attempts": 5},
+		},
+	})
+	outputs, err := GetOutputWriters(v, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := outputs[0].Writer.(*RetryWriter)
+	if !ok {
+		t.Fatalf("Expected a retry writer around the output, Got %T", outputs[0].Writer)
+	}
+	if r.cfg.Attempts != 5 || r.cfg.BreakerFailures != 5 || r.cfg.BreakerOpen != 30*time.Second {
+		t.Errorf("Incorrect retry settings. Got %+v", r.cfg)
+	}
+}
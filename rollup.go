@@ -1,118 +1,79 @@
 package funnel
 
 import (
-	"compress/gzip"
-	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
-
-	"github.com/fvbommel/sortorder"
 )
 
-// Renames a file with the current timestamp
+// Renames a file with the current timestamp, or as per the file name template
 func renameFileTimestamp(cfg *Config) (string, error) {
-	newFileName := time.Now().UTC().Format("2006-01-02_15-04-05.00000") + ".log"
-	err := os.Rename(
-		path.Join(cfg.DirName, cfg.ActiveFileName),
-		path.Join(cfg.DirName, newFileName),
-	)
-	return newFileName, err
+	return moveFileTimestamp(cfg, cfg.ActiveFileName, time.Now(), "")
+}
+
+// moveFileTimestamp gives the file the name for the time of its rotation.
+// The suffix is added to the name, if the file was compressed
+func moveFileTimestamp(cfg *Config, fileName string, t time.Time, suffix string) (string, error) {
+	namer, err := newFileNamer(cfg, false)
+	if err != nil {
+		return "", err
+	}
+	return namer.rotate(cfg.DirName, fileName, t, suffix)
 }
 
-// Renames files serially by increasing suffix by 1
+// Renames files serially by increasing their sequence no. by 1
 func renameFileSerial(cfg *Config) (string, error) {
+	return moveFileSerial(cfg, cfg.ActiveFileName, time.Now(), "")
+}
+
+// moveFileSerial moves the rotated files up by 1, and gives the file the first
+// name in the sequence. The suffix is added to the name, if the file was compressed
+func moveFileSerial(cfg *Config, fileName string, t time.Time, suffix string) (string, error) {
+	namer, err := newFileNamer(cfg, true)
+	if err != nil {
+		return "", err
+	}
 	// Read all the files from log dir
 	files, err := ioutil.ReadDir(cfg.DirName)
 	if err != nil {
 		return "", err
 	}
 
-	// Extracting the file names
-	var fileNames []string
-	for _, file := range files {
-		file.ModTime()
-		fileNames = append(fileNames, file.Name())
+	// Pick the rotated files, the ones without a sequence no. are left as they are
+	type rotatedFile struct {
+		name string
+		seq  int
 	}
-	// Sorting the files in natural order
-	sort.Sort(sortorder.Natural(fileNames))
-
-	// Reverse traversing the slice
-	for i := len(fileNames) - 1; i >= 0; i-- {
-		fileName := fileNames[i]
-		// Continuing if its the current file
-		if fileName == cfg.ActiveFileName {
-			continue
+	var rotated []rotatedFile
+	for _, file := range files {
+		if seq, ok := namer.match(file.Name()); ok && seq > 0 {
+			rotated = append(rotated, rotatedFile{file.Name(), seq})
 		}
-
-		// Check if the log file is prefixed with the correct active file name
-		if strings.HasPrefix(fileName, cfg.ActiveFileName) {
-			suffix := ".gz"
-			// Get the index from the file name
-			num := strings.TrimPrefix(fileName, cfg.ActiveFileName+".")
-			// Trim the suffix if ends with .gz
-			if strings.HasSuffix(fileName, suffix) {
-				num = strings.TrimSuffix(num, suffix)
-			}
-			intNum, err := strconv.Atoi(num)
-			if err != nil {
-				continue
-			}
-			// Now increase it by 1 and rename
-			intNum++
-			finalName := cfg.ActiveFileName + "." + strconv.Itoa(intNum)
-			// If ends with gz, add the gz suffix
-			if strings.HasSuffix(fileName, suffix) {
-				finalName += suffix
-			}
-			err = os.Rename(
-				path.Join(cfg.DirName, fileName),
-				path.Join(cfg.DirName, finalName),
-			)
-			if err != nil {
-				return "", err
-			}
+	}
+	// Renaming from the highest no. down, so that no file is overwritten
+	sort.Slice(rotated, func(i, j int) bool { return rotated[i].seq > rotated[j].seq })
+	for _, file := range rotated {
+		err = os.Rename(
+			path.Join(cfg.DirName, file.name),
+			path.Join(cfg.DirName, namer.withSeq(file.name, file.seq+1)),
+		)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	// Rename active file to file.1
+	// Rename the file to the first in the sequence
+	newFileName := namer.render(t, 1) + suffix
 	err = os.Rename(
-		path.Join(cfg.DirName, cfg.ActiveFileName),
-		path.Join(cfg.DirName, cfg.ActiveFileName+".1"),
+		path.Join(cfg.DirName, fileName),
+		path.Join(cfg.DirName, newFileName),
 	)
 	if err != nil {
 		return "", err
 	}
-	return cfg.ActiveFileName + ".1", nil
-}
-
-func gzipFile(sourcePath string) error {
-	reader, err := os.Open(sourcePath)
-	if err != nil {
-		return err
-	}
-	// Remove the old file once done
-	defer os.Remove(sourcePath)
-
-	target := sourcePath + ".gz"
-	// Open new gzip stream
-	writer, err := os.Create(target)
-	if err != nil {
-		return err
-	}
-	defer writer.Close()
-
-	archiver := gzip.NewWriter(writer)
-	archiver.Name = path.Base(sourcePath)
-	defer archiver.Close()
-
-	// Write to the gzip stream
-	_, err = io.Copy(archiver, reader)
-	return err
+	return newFileName, nil
 }
 
 // ByModTime implements sorting for files by mod time from recent to old
@@ -122,11 +83,58 @@ func (a ByModTime) Len() int           { return len(a) }
 func (a ByModTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByModTime) Less(i, j int) bool { return a[i].ModTime().Unix() > a[j].ModTime().Unix() }
 
-func deleteOldFiles(cfg *Config) error {
+// rotatedFiles returns the files in the log dir which were rotated by funnel.
+// Only the names given as per the file name template are picked, or the default
+// timestamp and serial ones if there is no template. The latter include the
+// timestamp names of older versions, so that they are removed once too old
+func rotatedFiles(cfg *Config) ([]os.FileInfo, error) {
+	var namers []*fileNamer
+	for _, serial := range []bool{false, true} {
+		namer, err := newFileNamer(cfg, serial)
+		if err != nil {
+			return nil, err
+		}
+		namers = append(namers, namer)
+		// Both are the same template
+		if cfg.FileNameTemplate != "" {
+			break
+		}
+	}
+	if cfg.FileNameTemplate == "" {
+		legacy := *cfg
+		legacy.FileNameTemplate = legacyTimestampTemplate
+		namer, err := newFileNamer(&legacy, false)
+		if err != nil {
+			return nil, err
+		}
+		namers = append(namers, namer)
+	}
+
 	// Read all the files from log dir
 	files, err := ioutil.ReadDir(cfg.DirName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var rotated []os.FileInfo
+	for _, file := range files {
+		for _, namer := range namers {
+			if _, ok := namer.match(file.Name()); ok && file.Mode().IsRegular() {
+				rotated = append(rotated, file)
+				break
+			}
+		}
+	}
+	return rotated, nil
+}
+
+// deleteOldFiles removes the rotated files which are too old, too many, or too big
+// together. It returns the no. of files removed and the bytes freed
+func deleteOldFiles(cfg *Config) (int, int64, error) {
+	// Only the files rotated by funnel are removed, so that
+	// other files in the log dir are left alone
+	files, err := rotatedFiles(cfg)
+	if err != nil {
+		return 0, 0, err
 	}
 
 	// sort files by mod time
@@ -134,30 +142,52 @@ func deleteOldFiles(cfg *Config) error {
 
 	t := time.Now().Unix()
 	t -= cfg.MaxAge
-	// iterate the list, oldest first
-	for i := len(files) - 1; i >= 0; i-- {
-		file := files[i]
-		// Never remove the active file
-		if file.Name() == cfg.ActiveFileName {
-			continue
-		}
-		modTime := file.ModTime().Unix()
-		// start removing from top if timestamp older than given
-		if modTime < t {
-			err := os.Remove(path.Join(cfg.DirName, file.Name()))
-			if err != nil {
-				return err
-			}
+	// iterate the list, latest first, picking the files to keep
+	var remove []os.FileInfo
+	var keptBytes int64
+	overLimit := false
+	for i, file := range files {
+		switch {
+		// timestamp older than given
+		case file.ModTime().Unix() < t:
+		// count is more than max. The active file is counted as one of them
+		case i+2 > cfg.MaxCount:
+		// the files kept so far and this one are bigger than max.
+		// The older ones are removed as well, even if they are small enough
+		case cfg.MaxTotalBytes > 0 && (overLimit || keptBytes+file.Size() > cfg.MaxTotalBytes):
+			overLimit = true
+		default:
+			keptBytes += file.Size()
 			continue
 		}
+		remove = append(remove, file)
+	}
 
-		// then check if remaining count is more than max, then keep deleting
-		if i+1 > cfg.MaxCount {
-			err := os.Remove(path.Join(cfg.DirName, file.Name()))
-			if err != nil {
-				return err
-			}
+	// remove them, oldest first
+	var freed int64
+	for i := len(remove) - 1; i >= 0; i-- {
+		if err := os.Remove(path.Join(cfg.DirName, remove[i].Name())); err != nil {
+			return len(remove) - 1 - i, freed, err
 		}
+		freed += remove[i].Size()
+	}
+	return len(remove), freed, nil
+}
+
+// nextRotation returns the time of the next rotation, interval after now.
+// If aligned, rotations happen on the boundaries of the interval in the given
+// timezone, counted from midnight. Intervals of whole days rotate at midnight
+func nextRotation(now time.Time, interval time.Duration, align bool, loc *time.Location) time.Time {
+	if !align {
+		return now.Add(interval)
+	}
+	now = now.In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	day := 24 * time.Hour
+	if interval%day == 0 {
+		// Days are added on the calendar, so that a DST change does not move it off midnight
+		return midnight.AddDate(0, 0, int(interval/day))
 	}
-	return nil
+	n := now.Sub(midnight)/interval + 1
+	return midnight.Add(n * interval)
 }
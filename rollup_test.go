@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -44,7 +45,7 @@ func TestRenameFileTimestamp(t *testing.T) {
 
 	dateRegex := "[0-9]{4}-[0-9]{2}-[0-9]{2}"
 	timeRegex := "[0-9]{2}-[0-9]{2}-[0-9]{2}.[0-9]{5}"
-	regexStr := fmt.Sprintf("%s_%s%s", dateRegex, timeRegex, ".log")
+	regexStr := fmt.Sprintf("^%s\\.%s_%s$", regexp.QuoteMeta(cfg.ActiveFileName), dateRegex, timeRegex)
 	for _, file := range files {
 		matched, err := regexp.MatchString(regexStr, file.Name())
 		if err != nil {
@@ -226,6 +227,133 @@ func TestOldFiles(t *testing.T) {
 	}
 }
 
+func TestMaxTotalBytes(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+	cfg.MaxCount = 100
+	cfg.MaxTotalBytes = 250
+
+	// out.log.1 is the latest
+	data := make([]byte, 100)
+	for i := 1; i <= 4; i++ {
+		fileName := path.Join(cfg.DirName, cfg.ActiveFileName+"."+strconv.Itoa(i))
+		if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-time.Duration(i) * time.Minute)
+		if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, freed, err := deleteOldFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || freed != 200 {
+		t.Errorf("Incorrect cleanup. Expected 2 files and 200 bytes, Got %d files and %d bytes", n, freed)
+	}
+	var fileNames []string
+	for _, file := range readTestDir(t, cfg.DirName) {
+		fileNames = append(fileNames, file.Name())
+	}
+	want := []string{"out.log.1", "out.log.2"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files left. Expected %v, Got %v", want, fileNames)
+	}
+}
+
+func TestMaxTotalBytesOlderFiles(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+	cfg.MaxCount = 100
+	cfg.MaxTotalBytes = 250
+
+	// out.log.1 is the latest. Once out.log.2 goes over the limit,
+	// the older files go too, even though they would fit
+	sizes := []int{50, 300, 50, 50}
+	for i, size := range sizes {
+		fileName := path.Join(cfg.DirName, cfg.ActiveFileName+"."+strconv.Itoa(i+1))
+		if err := ioutil.WriteFile(fileName, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-time.Duration(i+1) * time.Minute)
+		if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, freed, err := deleteOldFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || freed != 400 {
+		t.Errorf("Incorrect cleanup. Expected 3 files and 400 bytes, Got %d files and %d bytes", n, freed)
+	}
+	var fileNames []string
+	for _, file := range readTestDir(t, cfg.DirName) {
+		fileNames = append(fileNames, file.Name())
+	}
+	want := []string{"out.log.1"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files left. Expected %v, Got %v", want, fileNames)
+	}
+}
+
+func TestRetentionLeavesOtherFiles(t *testing.T) {
+	cfg := setupRollupTest(t)
+	defer os.RemoveAll(cfg.DirName)
+	cfg.ActiveFileName = "a.log"
+	cfg.MaxAge = 60
+
+	// Another funnel writes b.log to the same dir
+	names := []string{"a.log", "a.log.1", "a.log.2.gz", "a.log.2017-03-09_07-05-03.00000",
+		"2017-03-09_07-05-03.00000.log", "b.log", "b.log.1", "b.log.2017-03-09_07-05-03.00000.gz", "notes.txt", "a.log.old"}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range names {
+		if err := ioutil.WriteFile(path.Join(cfg.DirName, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path.Join(cfg.DirName, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := deleteOldFiles(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var fileNames []string
+	for _, file := range readTestDir(t, cfg.DirName) {
+		fileNames = append(fileNames, file.Name())
+	}
+	sort.Strings(fileNames)
+	want := []string{"a.log", "a.log.old", "b.log", "b.log.1", "b.log.2017-03-09_07-05-03.00000.gz", "notes.txt"}
+	if !reflect.DeepEqual(fileNames, want) {
+		t.Errorf("Incorrect files left. Expected %v, Got %v", want, fileNames)
+	}
+}
+
+func TestNextRotation(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	now := time.Date(2017, 3, 10, 14, 25, 30, 0, loc)
+	tests := []struct {
+		interval time.Duration
+		align    bool
+		want     time.Time
+	}{
+		{time.Hour, false, now.Add(time.Hour)},
+		{time.Hour, true, time.Date(2017, 3, 10, 15, 0, 0, 0, loc)},
+		{15 * time.Minute, true, time.Date(2017, 3, 10, 14, 30, 0, 0, loc)},
+		{24 * time.Hour, true, time.Date(2017, 3, 11, 0, 0, 0, 0, loc)},
+		{48 * time.Hour, true, time.Date(2017, 3, 12, 0, 0, 0, 0, loc)},
+	}
+	for _, test := range tests {
+		// The current time is in UTC, the boundaries are in the given timezone
+		got := nextRotation(now.UTC(), test.interval, test.align, loc)
+		if !got.Equal(test.want) {
+			t.Errorf("Incorrect next rotation for %v, aligned %v. Expected %v, Got %v", test.interval, test.align, test.want, got)
+		}
+	}
+}
+
 // Internal helper functions
 func setupRollupTest(t *testing.T) *Config {
 	dir, err := ioutil.TempDir("", "test")
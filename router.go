@@ -0,0 +1,124 @@
+package funnel
+
+import "errors"
+
+// ErrNoRouteOutputs is raised if a route does not list any outputs
+var ErrNoRouteOutputs = errors.New("every entry in " + Routes + " must list at least one output")
+
+// RouteConfig holds the settings of a single routing rule.
+// Lines matching the conditions are written to the listed outputs
+type RouteConfig struct {
+	Match   MatchConfig
+	Outputs []string
+}
+
+// UnknownRouteOutputError is raised if a route sends lines to an
+// output which is not one of the targets
+type UnknownRouteOutputError struct {
+	Output string
+}
+
+func (e *UnknownRouteOutputError) Error() string {
+	return "Route output " + e.Output + " is not one of the targets"
+}
+
+type route struct {
+	matcher *matcher
+	outputs []string
+}
+
+// Router decides which outputs a line gets written to. A line goes to the
+// outputs of every route it matches. Lines which match no route go to the
+// default outputs, which are all the targets unless configured otherwise
+type Router struct {
+	routes   []route
+	defaults []string
+	// reused between calls to avoid allocating for every line
+	selected map[string]bool
+}
+
+// GetRouter returns the router for the routes in the config.
+// It returns nil if there are no routes, in which case every line goes to every target
+func GetRouter(cfg *Config) (*Router, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+
+	r := &Router{
+		defaults: cfg.DefaultRoute,
+		selected: make(map[string]bool),
+	}
+	if len(r.defaults) == 0 {
+		r.defaults = cfg.Targets
+	}
+	if err := checkRouteOutputs(cfg, r.defaults); err != nil {
+		return nil, err
+	}
+
+	for _, rc := range cfg.Routes {
+		m, err := newMatcher(rc.Match)
+		if err != nil {
+			return nil, err
+		}
+		if len(rc.Outputs) == 0 {
+			return nil, ErrNoRouteOutputs
+		}
+		if err := checkRouteOutputs(cfg, rc.Outputs); err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, route{matcher: m, outputs: rc.Outputs})
+	}
+	return r, nil
+}
+
+func checkRouteOutputs(cfg *Config, outputs []string) error {
+	for _, o := range outputs {
+		if !cfg.hasTarget(o) {
+			return &UnknownRouteOutputError{o}
+		}
+	}
+	return nil
+}
+
+// outputs returns every output the router can send lines to
+func (r *Router) outputs() []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(outputs []string) {
+		for _, o := range outputs {
+			if !seen[o] {
+				seen[o] = true
+				names = append(names, o)
+			}
+		}
+	}
+	add(r.defaults)
+	for _, rt := range r.routes {
+		add(rt.outputs)
+	}
+	return names
+}
+
+// Route returns the set of outputs the line has to be written to.
+// The returned map is only valid till the next call
+func (r *Router) Route(line string) map[string]bool {
+	for k := range r.selected {
+		delete(r.selected, k)
+	}
+
+	l := newLogLine(line)
+	for _, rt := range r.routes {
+		if rt.matcher.match(l) {
+			for _, o := range rt.outputs {
+				r.selected[o] = true
+			}
+		}
+	}
+
+	if len(r.selected) == 0 {
+		for _, o := range r.defaults {
+			r.selected[o] = true
+		}
+	}
+	return r.selected
+}
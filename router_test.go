@@ -0,0 +1,90 @@
+package funnel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNoRoutes(t *testing.T) {
+	r, err := GetRouter(&Config{Targets: []string{"file"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Errorf("Expected nil router, Got %v", r)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	cfg := &Config{
+		Targets: []string{"file", "elasticsearch", "s3"},
+		Routes: []RouteConfig{
+			{Match: MatchConfig{Level: "error"}, Outputs: []string{"elasticsearch"}},
+			{Match: MatchConfig{}, Outputs: []string{"s3"}},
+			{Match: MatchConfig{Regex: "audit"}, Outputs: []string{"file"}},
+		},
+	}
+	r, err := GetRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line    string
+		outputs map[string]bool
+	}{
+		{"[ERROR] boom\n", map[string]bool{"elasticsearch": true, "s3": true}},
+		{"[INFO] all good\n", map[string]bool{"s3": true}},
+		{"[INFO] audit entry\n", map[string]bool{"s3": true, "file": true}},
+	}
+	for _, test := range tests {
+		if got := r.Route(test.line); !reflect.DeepEqual(got, test.outputs) {
+			t.Errorf("Incorrect outputs for %q. Expected %v, Got %v", test.line, test.outputs, got)
+		}
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	cfg := &Config{
+		Targets: []string{"file", "kafka"},
+		Routes: []RouteConfig{
+			{Match: MatchConfig{Level: "error"}, Outputs: []string{"kafka"}},
+		},
+	}
+	r, err := GetRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a default route, unmatched lines go everywhere
+	want := map[string]bool{"file": true, "kafka": true}
+	if got := r.Route("[INFO] hello\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect outputs. Expected %v, Got %v", want, got)
+	}
+
+	cfg.DefaultRoute = []string{"file"}
+	if r, err = GetRouter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]bool{"file": true}
+	if got := r.Route("[INFO] hello\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect outputs. Expected %v, Got %v", want, got)
+	}
+}
+
+func TestUnknownRouteOutput(t *testing.T) {
+	cfg := &Config{
+		Targets: []string{"file"},
+		Routes: []RouteConfig{
+			{Match: MatchConfig{Level: "error"}, Outputs: []string{"kafka"}},
+		},
+	}
+	_, err := GetRouter(cfg)
+	if rerr, ok := err.(*UnknownRouteOutputError); !ok || rerr.Output != "kafka" {
+		t.Errorf("Expected UnknownRouteOutputError for kafka, Got %v", err)
+	}
+
+	cfg.Routes[0].Outputs = nil
+	if _, err = GetRouter(cfg); err != ErrNoRouteOutputs {
+		t.Errorf("Expected ErrNoRouteOutputs, Got %v", err)
+	}
+}
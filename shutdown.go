@@ -0,0 +1,52 @@
+package funnel
+
+import (
+	"strconv"
+	"time"
+)
+
+// The codes funnel exits with, other than 0 for a clean shutdown
+const (
+	// ExitError means funnel stopped reading because of an error
+	ExitError = 1
+	// ExitLinesLost means some lines were dropped or could not be written,
+	// or the shutdown gave up on them after the drain timeout
+	ExitLinesLost = 3
+)
+
+// shutdown tells the feed goroutine to write the lines left, flush and close the outputs,
+// and waits for it till the drain timeout. It then logs the summary of the work done.
+// It runs only once, a second call waits for the first one to finish
+func (c *Consumer) shutdown() {
+	c.shutdownOnce.Do(func() {
+		drained := make(chan struct{})
+		c.wg.Add(1)
+		go func() {
+			// The feed goroutine may be stuck on an output which hangs
+			c.done <- struct{}{}
+			c.wg.Wait()
+			close(drained)
+		}()
+
+		var timeout <-chan time.Time
+		if c.drainTimeout > 0 {
+			timer := time.NewTimer(c.drainTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-drained:
+		case <-timeout:
+			c.stats.timedOut(len(c.feed))
+			c.Logger.Err("outputs were not closed within " + strconv.Itoa(int(c.drainTimeout/time.Second)) +
+				" seconds, giving up on the lines left")
+		}
+		c.logStats()
+	})
+}
+
+// ExitCode returns the code funnel should exit with once the consumer has stopped.
+// It is 0 if every line was written, and the outputs were closed within the drain timeout
+func (c *Consumer) ExitCode() int {
+	return c.stats.exitCode()
+}
@@ -0,0 +1,66 @@
+package funnel
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCleanShutdown(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	c.Start(strings.NewReader("one\ntwo\nthree\n"))
+	if code := c.ExitCode(); code != 0 {
+		t.Errorf("Expected exit code 0, Got %d", code)
+	}
+	s := c.Stats()
+	if s.LinesProcessed != 3 || s.BytesProcessed != 14 {
+		t.Errorf("Expected 3 lines and 14 bytes to be processed, Got %d and %d", s.LinesProcessed, s.BytesProcessed)
+	}
+}
+
+func TestLostLinesExitCode(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	c.LineProcessor = failingProcessor{}
+	c.Config.ErrorsProcessor = PolicySkipAndLog
+
+	c.Start(strings.NewReader("one\nbad\nthree\n"))
+	if code := c.ExitCode(); code != ExitLinesLost {
+		t.Errorf("Expected exit code %d, Got %d", ExitLinesLost, code)
+	}
+	if n := c.Stats().LinesLost; n != 1 {
+		t.Errorf("Expected 1 line to be lost, Got %d", n)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	out := &blockingOutput{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(out.release)
+	c.Config.Targets = []string{"blocking"}
+	c.Outputs = []*Output{{Name: "blocking", Writer: out}}
+	c.Config.ShutdownDrainTimeoutSecs = 1
+	// The lines do not wait for the output to take them
+	c.Config.QueueSize = 10
+
+	// The output hangs on the first line, so the shutdown gives up on it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	start := time.Now()
+	go func() {
+		c.Start(strings.NewReader("one\ntwo\n"))
+		wg.Done()
+	}()
+	wg.Wait()
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("Expected the shutdown to take about a second, Took %v", d)
+	}
+	if code := c.ExitCode(); code != ExitLinesLost {
+		t.Errorf("Expected exit code %d, Got %d", ExitLinesLost, code)
+	}
+}
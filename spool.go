@@ -0,0 +1,464 @@
+package funnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// spoolSegmentBytes is the size beyond which a new segment file is started
+	spoolSegmentBytes = 1 << 20 // 1MB
+	// spoolCommitEvery is the max no. of lines delivered before the position is saved
+	spoolCommitEvery = 1000
+	// spoolRetryMin and spoolRetryMax bound the wait between delivery attempts
+	spoolRetryMin = 500 * time.Millisecond
+	spoolRetryMax = 30 * time.Second
+
+	spoolSegmentSuffix = ".seg"
+	spoolOffsetFile    = "offset"
+)
+
+// ErrCorruptSpool is raised if a record in the spool can not be read back
+var ErrCorruptSpool = errors.New("spool segment is corrupt")
+
+// ErrSpoolFull is returned for a line dropped because the spool is full
+var ErrSpoolFull = errors.New("spool is full, line dropped")
+
+// SpoolWriter is a write-ahead spool in front of an output. Every line is first
+// appended to segment files on disk, and a background goroutine delivers them
+// to the output in the same order. If the output fails, delivery is retried
+// with backoff until it recovers.
+//
+// The position up to which lines have been delivered and flushed is saved on disk,
+// so that delivery resumes from there after a restart. Lines may be delivered
+// more than once if funnel crashes in between, but none are lost.
+// If the spool grows beyond maxBytes, new lines are dropped with ErrSpoolFull till there is room
+type SpoolWriter struct {
+	out      OutputWriter
+	name     string
+	dir      string
+	maxBytes int64
+	segBytes int64
+	logger   *syslog.Writer
+
+	mu      sync.Mutex
+	w       *os.File // segment being written
+	wSeq    uint64
+	wSize   int64
+	total   int64 // bytes in all the segments on disk
+	dropped uint64
+
+	// reader state, owned by the delivery goroutine
+	r         *os.File
+	rSeq      uint64
+	rPos      int64
+	rSize     int64 // size of the segment being read, if it is not being written anymore
+	pending   int   // lines delivered since the position was last saved
+	failing   bool
+	lastError error
+
+	notify  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewSpoolWriter opens the spool in the directory, creating it if needed,
+// and starts delivering whatever is left in it to the output
+func NewSpoolWriter(out OutputWriter, name, dir string, maxBytes int64, logger *syslog.Writer) (*SpoolWriter, error) {
+	s := &SpoolWriter{
+		out:      out,
+		name:     name,
+		dir:      dir,
+		maxBytes: maxBytes,
+		segBytes: spoolSegmentBytes,
+		logger:   logger,
+		notify:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.deliver()
+	return s, nil
+}
+
+// open finds the segments left from before and the position to resume from
+func (s *SpoolWriter) open() error {
+	if err := os.MkdirAll(s.dir, 0775); err != nil {
+		return err
+	}
+	seqs, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	// Resume from the saved position, or from the oldest segment if there is none
+	if b, err := ioutil.ReadFile(path.Join(s.dir, spoolOffsetFile)); err == nil {
+		if _, err := fmt.Sscanf(string(b), "%d %d", &s.rSeq, &s.rPos); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// Start from the oldest segment if there was no saved position, or if
+	// the segment it points to is gone
+	if len(seqs) > 0 && s.rSeq < seqs[0] {
+		s.rSeq = seqs[0]
+		s.rPos = 0
+	}
+
+	// Segments which have been delivered completely are not needed anymore
+	for _, seq := range seqs {
+		if seq < s.rSeq {
+			os.Remove(s.segmentPath(seq))
+			continue
+		}
+		fi, err := os.Stat(s.segmentPath(seq))
+		if err != nil {
+			return err
+		}
+		s.total += fi.Size()
+	}
+
+	// Append to the last segment, after its last complete record.
+	// A crash in the middle of a write may have left a partial one
+	s.wSeq = s.rSeq
+	if len(seqs) > 0 && seqs[len(seqs)-1] > s.wSeq {
+		s.wSeq = seqs[len(seqs)-1]
+	}
+	s.w, err = os.OpenFile(s.segmentPath(s.wSeq), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := s.w.Stat()
+	if err != nil {
+		return err
+	}
+	valid := validRecords(s.w, fi.Size())
+	if valid < fi.Size() {
+		if err := s.w.Truncate(valid); err != nil {
+			return err
+		}
+		s.total -= fi.Size() - valid
+	}
+	if _, err := s.w.Seek(valid, io.SeekStart); err != nil {
+		return err
+	}
+	s.wSize = valid
+
+	s.r, err = os.Open(s.segmentPath(s.rSeq))
+	if err != nil {
+		return err
+	}
+	return s.setReadSize()
+}
+
+// segments returns the sequence numbers of the segment files in increasing order
+func (s *SpoolWriter) segments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), spoolSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func (s *SpoolWriter) segmentPath(seq uint64) string {
+	return path.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+}
+
+// validRecords returns the size of the file up to the end of its last complete record
+func validRecords(r io.ReaderAt, size int64) int64 {
+	var pos int64
+	var header [4]byte
+	for pos+4 <= size {
+		if _, err := r.ReadAt(header[:], pos); err != nil {
+			break
+		}
+		end := pos + 4 + int64(binary.BigEndian.Uint32(header[:]))
+		if end > size {
+			break
+		}
+		pos = end
+	}
+	return pos
+}
+
+// Write appends the line to the spool. The line is delivered to the output later
+func (s *SpoolWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(4 + len(p))
+	if s.total+size > s.maxBytes {
+		s.dropped++
+		if s.dropped == 1 {
+			s.logger.Warning("spool of output " + s.name + " is full, dropping lines")
+		}
+		return 0, ErrSpoolFull
+	}
+	if s.wSize > 0 && s.wSize+size > s.segBytes {
+		if err := s.nextWriteSegment(); err != nil {
+			return 0, err
+		}
+	}
+
+	// The record is written in one go, so that a crash leaves at most one partial record
+	rec := make([]byte, size)
+	binary.BigEndian.PutUint32(rec, uint32(len(p)))
+	copy(rec[4:], p)
+	if _, err := s.w.Write(rec); err != nil {
+		return 0, err
+	}
+	s.wSize += size
+	s.total += size
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// nextWriteSegment starts a new segment to write to. It is called with the lock held
+func (s *SpoolWriter) nextWriteSegment() error {
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.segmentPath(s.wSeq+1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	s.wSeq++
+	s.wSize = 0
+	return nil
+}
+
+// Flush syncs the spool to disk. The output itself is flushed by the delivery goroutine
+func (s *SpoolWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Sync()
+}
+
+// Close delivers whatever it can without waiting for a failing output,
+// and then closes the output. Lines not delivered remain in the spool for the next start
+func (s *SpoolWriter) Close() error {
+	close(s.closing)
+	<-s.done
+
+	s.mu.Lock()
+	dropped := s.dropped
+	err := s.w.Close()
+	s.mu.Unlock()
+	s.r.Close()
+	if dropped > 0 {
+		s.logger.Warning("spool of output " + s.name + " dropped " + strconv.FormatUint(dropped, 10) + " lines")
+	}
+
+	if cerr := s.out.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// deliver sends the lines in the spool to the output, in order
+func (s *SpoolWriter) deliver() {
+	defer close(s.done)
+	backoff := spoolRetryMin
+	for {
+		err := s.deliverNext()
+		if err == nil {
+			backoff = spoolRetryMin
+			continue
+		}
+
+		if err == io.EOF {
+			// Everything is delivered, so wait for more
+			select {
+			case <-s.notify:
+				continue
+			case <-s.closing:
+				return
+			}
+		}
+
+		// The output failed. Try again after a while, unless funnel is shutting down
+		if !s.failing || err.Error() != s.lastError.Error() {
+			s.logger.Err("output " + s.name + " failed, lines are kept in the spool - " + err.Error())
+		}
+		s.failing = true
+		s.lastError = err
+		select {
+		case <-time.After(backoff):
+		case <-s.closing:
+			return
+		}
+		backoff *= 2
+		if backoff > spoolRetryMax {
+			backoff = spoolRetryMax
+		}
+	}
+}
+
+// deliverNext writes the next line to the output. Once it has caught up, or has written
+// enough lines, it flushes the output and saves the position.
+// It returns io.EOF if there is nothing left to deliver
+func (s *SpoolWriter) deliverNext() error {
+	rec, err := s.readRecord()
+	if err == io.EOF {
+		if s.pending > 0 {
+			if err := s.commit(); err != nil {
+				return err
+			}
+		}
+		return s.nextReadSegment()
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.out.Write(rec); s.dropRejected(err) != nil {
+		return err
+	}
+	s.rPos += int64(4 + len(rec))
+	s.pending++
+	if s.failing {
+		s.logger.Info("output " + s.name + " recovered, delivering the spooled lines")
+		s.failing = false
+	}
+	if s.pending >= spoolCommitEvery {
+		return s.commit()
+	}
+	return nil
+}
+
+// dropRejected logs the lines which the output rejected, as trying them again would
+// not help. It returns any other error as is
+func (s *SpoolWriter) dropRejected(err error) error {
+	rejErr, ok := err.(*RejectedError)
+	if !ok {
+		return err
+	}
+	s.logger.Err("output " + s.name + " - " + rejErr.Error() + ", dropping them from the spool")
+	return nil
+}
+
+// readRecord reads the record at the read position, without moving past it
+func (s *SpoolWriter) readRecord() ([]byte, error) {
+	s.mu.Lock()
+	writing := s.rSeq == s.wSeq
+	limit := s.wSize
+	s.mu.Unlock()
+	if !writing {
+		// The segment is complete, so its size does not change anymore
+		if s.rPos >= s.rSize {
+			if err := s.setReadSize(); err != nil {
+				return nil, err
+			}
+		}
+		limit = s.rSize
+	}
+	if s.rPos+4 > limit {
+		return nil, io.EOF
+	}
+
+	var header [4]byte
+	if _, err := s.r.ReadAt(header[:], s.rPos); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[:]))
+	if s.rPos+4+n > limit {
+		return nil, ErrCorruptSpool
+	}
+	rec := make([]byte, n)
+	if _, err := s.r.ReadAt(rec, s.rPos+4); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// commit flushes the output and saves the position up to which lines are delivered
+func (s *SpoolWriter) commit() error {
+	if err := s.out.Flush(); s.dropRejected(err) != nil {
+		return err
+	}
+	tmp := path.Join(s.dir, spoolOffsetFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", s.rSeq, s.rPos)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(s.dir, spoolOffsetFile)); err != nil {
+		return err
+	}
+	s.pending = 0
+	return nil
+}
+
+// nextReadSegment moves on to the next segment once the one being read is complete,
+// removing it. It returns io.EOF if there is nothing more to read for now
+func (s *SpoolWriter) nextReadSegment() error {
+	s.mu.Lock()
+	writing := s.rSeq == s.wSeq
+	s.mu.Unlock()
+	if writing {
+		return io.EOF
+	}
+
+	f, err := os.Open(s.segmentPath(s.rSeq + 1))
+	if err != nil {
+		return err
+	}
+	old, oldSeq, oldSize := s.r, s.rSeq, s.rSize
+	s.r = f
+	s.rSeq++
+	s.rPos = 0
+	s.rSize = 0
+	// The new position is saved before the old segment is removed,
+	// so that the saved position always points to an existing segment
+	if err := s.commit(); err != nil {
+		return err
+	}
+	old.Close()
+	os.Remove(s.segmentPath(oldSeq))
+	s.mu.Lock()
+	s.total -= oldSize
+	s.mu.Unlock()
+	return nil
+}
+
+// setReadSize notes the size of the segment being read, if it is complete
+func (s *SpoolWriter) setReadSize() error {
+	fi, err := s.r.Stat()
+	if err != nil {
+		return err
+	}
+	s.rSize = fi.Size()
+	return nil
+}
@@ -0,0 +1,224 @@
+package funnel
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// flakyOutput fails every write and flush while it is down
+type flakyOutput struct {
+	recordingOutput
+	down bool
+}
+
+var errOutputDown = errors.New("output is down")
+
+func (f *flakyOutput) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyOutput) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		return 0, errOutputDown
+	}
+	return f.recordingOutput.Write(p)
+}
+
+func (f *flakyOutput) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errOutputDown
+	}
+	f.flushes++
+	return nil
+}
+
+func numberedLines(from, to int) []string {
+	var lines []string
+	for i := from; i <= to; i++ {
+		lines = append(lines, "line "+strconv.Itoa(i)+"\n")
+	}
+	return lines
+}
+
+// waitForLines waits till the output has got the expected no. of lines
+func waitForLines(t *testing.T, out *flakyOutput, n int) {
+	for i := 0; len(out.getLines()) < n; i++ {
+		if i == 300 {
+			t.Fatalf("Lines were not delivered. Expected %d, Got %d", n, len(out.getLines()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestSpool(t *testing.T, out OutputWriter, dir string, maxBytes int64) *SpoolWriter {
+	s, err := NewSpoolWriter(out, "test", dir, maxBytes, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small segments, to have the lines spread across many of them
+	s.mu.Lock()
+	s.segBytes = 64
+	s.mu.Unlock()
+	return s
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &flakyOutput{down: true}
+	s := newTestSpool(t, out, dir, 1<<20)
+	for _, line := range numberedLines(1, 20) {
+		if _, err := s.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(out.getLines()) != 0 {
+		t.Fatal("Expected no lines to be delivered while the output is down")
+	}
+
+	out.setDown(false)
+	waitForLines(t, out, 20)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.getLines(), numberedLines(1, 20)) {
+		t.Errorf("Incorrect lines delivered. Got %q", out.getLines())
+	}
+	// Delivered segments are removed
+	seqs, err := s.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 1 {
+		t.Errorf("Incorrect no. of segments left. Expected 1, Got %d", len(seqs))
+	}
+}
+
+func TestSpoolResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The first run delivers some lines and then the output goes down
+	out := &flakyOutput{}
+	s := newTestSpool(t, out, dir, 1<<20)
+	for _, line := range numberedLines(1, 5) {
+		s.Write([]byte(line))
+	}
+	waitForLines(t, out, 5)
+	out.setDown(true)
+	for _, line := range numberedLines(6, 15) {
+		s.Write([]byte(line))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash in the middle of a write leaves a partial record at the end
+	seqs, err := s.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(s.segmentPath(seqs[len(seqs)-1]), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 100, 'x'})
+	f.Close()
+
+	// The next run carries on from where the first one left
+	out2 := &flakyOutput{}
+	s2 := newTestSpool(t, out2, dir, 1<<20)
+	for _, line := range numberedLines(16, 20) {
+		s2.Write([]byte(line))
+	}
+	waitForLines(t, out2, 15)
+	if err := s2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out2.getLines(), numberedLines(6, 20)) {
+		t.Errorf("Incorrect lines delivered after restart. Got %q", out2.getLines())
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &flakyOutput{down: true}
+	// Room for 3 lines of 7 bytes along with their headers
+	s := newTestSpool(t, out, dir, 33)
+	// The lines which do not fit are dropped with an error, so that they are counted as lost
+	for i, line := range numberedLines(1, 5) {
+		_, err := s.Write([]byte(line))
+		if i < 3 && err != nil {
+			t.Fatal(err)
+		}
+		if i >= 3 && err != ErrSpoolFull {
+			t.Errorf("Expected %v for line %d, Got %v", ErrSpoolFull, i+1, err)
+		}
+	}
+	out.setDown(false)
+	waitForLines(t, out, 3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.getLines(), numberedLines(1, 3)) {
+		t.Errorf("Incorrect lines delivered. Got %q", out.getLines())
+	}
+	if s.dropped != 2 {
+		t.Errorf("Incorrect no. of lines dropped. Expected 2, Got %d", s.dropped)
+	}
+}
+
+func TestSpoolFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	RegisterNewWriter("test", newTestOutput)
+	v := viper.New()
+	v.Set(Targets, []interface{}{
+		map[string]interface{}{
+			"name":  "test",
+			"spool": map[string]interface{}{"directory": dir},
+		},
+	})
+	outputs, err := GetOutputWriters(v, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := outputs[0].Writer.(*SpoolWriter); !ok {
+		t.Fatalf("Expected a spool in front of the output, Got %T", outputs[0].Writer)
+	}
+	if _, err := os.Stat(path.Join(dir, "test")); err != nil {
+		t.Errorf("Expected the spool directory to be named after the output - %v", err)
+	}
+	outputs[0].Writer.Close()
+}
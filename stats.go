@@ -0,0 +1,156 @@
+package funnel
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Stats holds the counters of the work done by the consumer so far
+type Stats struct {
+	// LinesProcessed and BytesProcessed are the no. of lines and bytes taken off the queue
+	LinesProcessed uint64
+	BytesProcessed uint64
+	// LinesFiltered is the no. of lines dropped by each filter rule
+	LinesFiltered map[string]uint64
+	// LongLines is the no. of lines which were longer than the max line length
+	LongLines uint64
+	// LinesDropped is the no. of lines dropped because the queue was full
+	LinesDropped uint64
+	// LinesLost is the no. of lines which could not be written to every output they were
+	// meant for, along with the lines left in the queue if the shutdown timed out
+	LinesLost uint64
+	// ErrorsSkipped is the no. of errors skipped in each stage, as per its error policy
+	ErrorsSkipped map[string]uint64
+}
+
+// statsTracker updates the counters from the feed goroutine,
+// while letting others read them at any time
+type statsTracker struct {
+	mu    sync.Mutex
+	stats Stats
+	// drainTimedOut is set if the outputs were not closed within the drain timeout
+	drainTimedOut bool
+	// failed is set if the consumer stopped reading because of an error
+	failed bool
+}
+
+func (st *statsTracker) lineProcessed(bytes int) {
+	st.mu.Lock()
+	st.stats.LinesProcessed++
+	st.stats.BytesProcessed += uint64(bytes)
+	st.mu.Unlock()
+}
+
+func (st *statsTracker) lineLost() {
+	st.linesLost(1)
+}
+
+func (st *statsTracker) linesLost(n int) {
+	st.mu.Lock()
+	st.stats.LinesLost += uint64(n)
+	st.mu.Unlock()
+}
+
+// timedOut records a shutdown which gave up on the lines left in the queue
+func (st *statsTracker) timedOut(linesLeft int) {
+	st.mu.Lock()
+	st.drainTimedOut = true
+	st.stats.LinesLost += uint64(linesLeft)
+	st.mu.Unlock()
+}
+
+func (st *statsTracker) fail() {
+	st.mu.Lock()
+	st.failed = true
+	st.mu.Unlock()
+}
+
+// exitCode returns 0 if every line was written and the shutdown was in time,
+// or else the code telling what went wrong
+func (st *statsTracker) exitCode() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.failed {
+		return ExitError
+	}
+	if st.drainTimedOut || st.stats.LinesDropped > 0 || st.stats.LinesLost > 0 {
+		return ExitLinesLost
+	}
+	return 0
+}
+
+func (st *statsTracker) lineFiltered(rule string) {
+	st.mu.Lock()
+	if st.stats.LinesFiltered == nil {
+		st.stats.LinesFiltered = make(map[string]uint64)
+	}
+	st.stats.LinesFiltered[rule]++
+	st.mu.Unlock()
+}
+
+func (st *statsTracker) errorSkipped(stage string) {
+	st.mu.Lock()
+	if st.stats.ErrorsSkipped == nil {
+		st.stats.ErrorsSkipped = make(map[string]uint64)
+	}
+	st.stats.ErrorsSkipped[stage]++
+	st.mu.Unlock()
+}
+
+func (st *statsTracker) longLine() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.stats.LongLines++
+	return st.stats.LongLines
+}
+
+func (st *statsTracker) lineDropped() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.stats.LinesDropped++
+	return st.stats.LinesDropped
+}
+
+// snapshot returns a copy of the counters
+func (st *statsTracker) snapshot() Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.stats
+	s.LinesFiltered = make(map[string]uint64, len(st.stats.LinesFiltered))
+	for rule, n := range st.stats.LinesFiltered {
+		s.LinesFiltered[rule] = n
+	}
+	s.ErrorsSkipped = make(map[string]uint64, len(st.stats.ErrorsSkipped))
+	for stage, n := range st.stats.ErrorsSkipped {
+		s.ErrorsSkipped[stage] = n
+	}
+	return s
+}
+
+// Stats returns the counters of the work done by the consumer so far.
+// It is safe to call it while the consumer is running
+func (c *Consumer) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// logStats writes the counters to syslog
+func (c *Consumer) logStats() {
+	s := c.Stats()
+	c.Logger.Info("processed " + strconv.FormatUint(s.LinesProcessed, 10) + " lines, " +
+		strconv.FormatUint(s.BytesProcessed, 10) + " bytes")
+	if s.LinesLost > 0 {
+		c.Logger.Warning(strconv.FormatUint(s.LinesLost, 10) + " lines were lost")
+	}
+	if s.LongLines > 0 {
+		c.Logger.Info(strconv.FormatUint(s.LongLines, 10) + " lines were longer than " + InputMaxLineBytes)
+	}
+	if s.LinesDropped > 0 {
+		c.Logger.Warning(strconv.FormatUint(s.LinesDropped, 10) + " lines were dropped because the queue was full")
+	}
+	for rule, n := range s.LinesFiltered {
+		c.Logger.Info("filter rule " + rule + " dropped " + strconv.FormatUint(n, 10) + " lines")
+	}
+	for stage, n := range s.ErrorsSkipped {
+		c.Logger.Warning(strconv.FormatUint(n, 10) + " errors were skipped in the " + stage + " stage")
+	}
+}
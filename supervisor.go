@@ -0,0 +1,294 @@
+package funnel
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrNoCommand is raised if the supervisor is not given an app to run
+	ErrNoCommand = errors.New("no command given to run")
+	// ErrConsumerStopped is returned to writes of the app output
+	// after the consumer has stopped reading
+	ErrConsumerStopped = errors.New("consumer has stopped reading")
+)
+
+// forwardedSignals are the signals which are passed on to the supervised app.
+// SIGHUP and SIGUSR1 are kept by funnel, to reload the config and rotate the file
+var forwardedSignals = []os.Signal{
+	os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR2,
+}
+
+// stopSignals are the signals after which the app is not restarted anymore
+var stopSignals = map[os.Signal]bool{
+	os.Interrupt:    true,
+	syscall.SIGTERM: true,
+	syscall.SIGQUIT: true,
+}
+
+// Supervisor runs an app as a child process and feeds its stdout and stderr
+// to the consumer as separate streams, named stdout and stderr.
+// Signals received by funnel are forwarded to the app, except for SIGHUP and SIGUSR1.
+//
+// Depending on the restart policy, the app is restarted when it exits, waiting
+// longer after every restart. Every exit and restart of the app is written
+// as a line of the supervisor stream
+type Supervisor struct {
+	Consumer *Consumer
+	// Command is the path to the app followed by its arguments
+	Command []string
+
+	events io.Writer
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	stop     chan struct{}
+}
+
+// Run starts the app and waits for it to exit, restarting it as per the restart policy.
+// It returns the last exit code of the app, or 128 plus the signal number if the app
+// was killed by a signal
+func (s *Supervisor) Run() (int, error) {
+	if len(s.Command) == 0 {
+		return 0, ErrNoCommand
+	}
+	cfg := s.Consumer.Config
+
+	// The streams are kept open across restarts of the app
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	eventR, eventW := io.Pipe()
+	s.events = eventW
+	s.stop = make(chan struct{})
+
+	consumerDone := make(chan struct{})
+	s.Consumer.supervised = true
+	go func() {
+		s.Consumer.StartStreams(
+			InputStream{Name: "stdout", Reader: outR},
+			InputStream{Name: "stderr", Reader: errR},
+			InputStream{Name: "supervisor", Reader: eventR},
+		)
+		// The consumer stops reading early if it runs into an error.
+		// Writes to the streams must not block after that
+		outR.CloseWithError(ErrConsumerStopped)
+		errR.CloseWithError(ErrConsumerStopped)
+		eventR.CloseWithError(ErrConsumerStopped)
+		close(consumerDone)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	go s.forwardSignals(sigs)
+
+	code, err := s.supervise(cfg, &streamWriter{w: outW}, &streamWriter{w: errW}, consumerDone)
+
+	signal.Stop(sigs)
+	close(sigs)
+	outW.Close()
+	errW.Close()
+	eventW.Close()
+	<-consumerDone
+	return code, err
+}
+
+// supervise runs the app till it does not have to be restarted anymore
+func (s *Supervisor) supervise(cfg *Config, stdout, stderr *streamWriter, consumerDone chan struct{}) (int, error) {
+	initialDelay := time.Duration(cfg.SupervisorRestartDelayMillis) * time.Millisecond
+	maxDelay := time.Duration(cfg.SupervisorRestartMaxDelaySecs) * time.Second
+	window := time.Duration(cfg.SupervisorRestartWindowSecs) * time.Second
+
+	delay := initialDelay
+	var restarts []time.Time
+	for {
+		started := time.Now()
+		code, err := s.runApp(stdout, stderr, consumerDone)
+		if err != nil {
+			s.event("app could not be run: " + err.Error())
+			return code, err
+		}
+		// A partial last line of the app is not joined to the first line after a restart
+		stdout.endLine()
+		stderr.endLine()
+		s.event("app exited with code " + strconv.Itoa(code))
+
+		if !s.restart(cfg.SupervisorRestart, code, consumerDone) {
+			return code, nil
+		}
+
+		// An app which ran for a while gets restarted quickly again
+		if time.Since(started) >= window {
+			delay = initialDelay
+		}
+		// Too many restarts within the window means the app is in a crash loop
+		now := time.Now()
+		for len(restarts) > 0 && now.Sub(restarts[0]) >= window {
+			restarts = restarts[1:]
+		}
+		if len(restarts) >= cfg.SupervisorMaxRestarts {
+			s.event("app is in a crash loop, " + strconv.Itoa(len(restarts)) + " restarts in " +
+				window.String() + ". Not restarting it anymore")
+			return code, nil
+		}
+		restarts = append(restarts, now)
+
+		s.event("restarting app in " + delay.String())
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return code, nil
+		case <-consumerDone:
+			return code, nil
+		}
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// runApp runs the app once and returns its exit code
+func (s *Supervisor) runApp(stdout, stderr io.Writer, consumerDone chan struct{}) (int, error) {
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	s.mu.Lock()
+	if err := cmd.Start(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	s.cmd = cmd
+	s.mu.Unlock()
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-waitErr:
+	case <-consumerDone:
+		// Nothing is reading the output of the app anymore
+		cmd.Process.Kill()
+		err = <-waitErr
+	}
+
+	s.mu.Lock()
+	s.cmd = nil
+	s.mu.Unlock()
+	return exitCode(err)
+}
+
+// restart returns true if the app has to be started again
+func (s *Supervisor) restart(policy string, code int, consumerDone chan struct{}) bool {
+	s.mu.Lock()
+	stopping := s.stopping
+	s.mu.Unlock()
+	if stopping {
+		return false
+	}
+	select {
+	case <-consumerDone:
+		return false
+	default:
+	}
+
+	switch policy {
+	case "always":
+		return true
+	case "on-failure":
+		return code != 0
+	}
+	return false
+}
+
+// forwardSignals passes on the signals to the app while it is running
+func (s *Supervisor) forwardSignals(sigs chan os.Signal) {
+	for sig := range sigs {
+		s.mu.Lock()
+		if stopSignals[sig] && !s.stopping {
+			s.stopping = true
+			close(s.stop)
+		}
+		if s.cmd != nil {
+			s.cmd.Process.Signal(sig)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// event writes a line to the supervisor stream, and to syslog
+func (s *Supervisor) event(msg string) {
+	s.Consumer.Logger.Info(msg)
+	io.WriteString(s.events, msg+"\n")
+}
+
+// exitCode returns the code with which the app exited
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, err
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 1, nil
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return status.ExitStatus(), nil
+}
+
+// streamWriter passes the output of the app on to its stream,
+// keeping track of whether the last line written was complete
+type streamWriter struct {
+	w       io.Writer
+	partial bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	if n > 0 {
+		sw.partial = p[n-1] != '\n'
+	}
+	return n, err
+}
+
+// endLine completes the last line, if needed
+func (sw *streamWriter) endLine() {
+	if sw.partial {
+		sw.w.Write([]byte("\n"))
+		sw.partial = false
+	}
+}
+
+// tagLine tags the line with the name of the stream it was read from.
+// A stream field is added to JSON objects, and other lines are prefixed with stream=<name>
+func tagLine(line, stream string) string {
+	if stream == "" {
+		return line
+	}
+	text, newline := splitNewline(line)
+	if obj, ok := parseJSONObject(text); ok {
+		// The rest of the object is kept as it is
+		tagged, err := addJSONFields(text, obj, map[string]interface{}{"stream": stream})
+		if err != nil {
+			return line
+		}
+		return tagged + newline
+	}
+	return "stream=" + stream + " " + line
+}
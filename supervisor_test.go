@@ -0,0 +1,191 @@
+package funnel
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTagLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		stream string
+		want   string
+	}{
+		{"hello\n", "", "hello\n"},
+		{"hello\n", "stdout", "stream=stdout hello\n"},
+		{`{"msg":"hello"}` + "\n", "stderr", `{"msg":"hello","stream":"stderr"}` + "\n"},
+		{`{"stream":"mine"}` + "\n", "stderr", `{"stream":"mine"}` + "\n"},
+		// Numbers, escapes and the order of the keys are not changed
+		{`{"id":9007199254740993,"msg":"a<b"}` + "\n", "stdout", `{"id":9007199254740993,"msg":"a<b","stream":"stdout"}` + "\n"},
+		{` { "z": 1 , "a": "\u00e9" } `, "stdout", ` { "z": 1 , "a": "\u00e9" ,"stream":"stdout"} `},
+	}
+	for _, test := range tests {
+		if got := tagLine(test.line, test.stream); got != test.want {
+			t.Errorf("Incorrect tagged line for %q. Expected %q, Got %q", test.line, test.want, got)
+		}
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+
+	s := &Supervisor{
+		Consumer: c,
+		Command:  []string{"sh", "-c", "echo out; echo err >&2; exit 3"},
+	}
+	code, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("Incorrect exit code. Expected 3, Got %d", code)
+	}
+
+	lines := rec.getLines()
+	sort.Strings(lines)
+	want := []string{"stream=stderr err\n", "stream=stdout out\n", "stream=supervisor app exited with code 3\n"}
+	if strings.Join(lines, "") != strings.Join(want, "") {
+		t.Errorf("Incorrect lines written. Expected %q, Got %q", want, lines)
+	}
+}
+
+func TestSupervisorForwardsSignals(t *testing.T) {
+	dir, c := setupTest(t)
+	defer os.RemoveAll(dir)
+	rec := &recordingOutput{}
+	c.Config.Targets = []string{"recorder"}
+	c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+
+	s := &Supervisor{
+		Consumer: c,
+		Command:  []string{"sh", "-c", "trap 'echo bye; exit 7' TERM; echo ready; while :; do sleep 0.1; done"},
+	}
+	done := make(chan int)
+	go func() {
+		code, err := s.Run()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- code
+	}()
+
+	// The app is running once it has written its first line
+	for i := 0; len(rec.getLines()) == 0; i++ {
+		if i == 100 {
+			t.Fatal("App did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case code := <-done:
+		if code != 7 {
+			t.Errorf("Incorrect exit code. Expected 7, Got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("App did not exit after the signal")
+	}
+	want := "stream=stdout ready\nstream=stdout bye\n"
+	if got := strings.Join(streamLines(rec.getLines(), "stdout"), ""); got != want {
+		t.Errorf("Incorrect lines written. Expected %q, Got %q", want, got)
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	tests := []struct {
+		policy   string
+		exitCode int
+		runs     int
+		events   []string
+	}{
+		{"no", 1, 1, []string{
+			"app exited with code 1",
+		}},
+		{"on-failure", 0, 1, []string{
+			"app exited with code 0",
+		}},
+		{"on-failure", 1, 3, []string{
+			"app exited with code 1",
+			"restarting app in 10ms",
+			"app exited with code 1",
+			"restarting app in 20ms",
+			"app exited with code 1",
+			"app is in a crash loop, 2 restarts in 1m0s. Not restarting it anymore",
+		}},
+		{"always", 0, 3, []string{
+			"app exited with code 0",
+			"restarting app in 10ms",
+			"app exited with code 0",
+			"restarting app in 20ms",
+			"app exited with code 0",
+			"app is in a crash loop, 2 restarts in 1m0s. Not restarting it anymore",
+		}},
+	}
+
+	for _, test := range tests {
+		dir, c := setupTest(t)
+		rec := &recordingOutput{}
+		c.Config.Targets = []string{"recorder"}
+		c.Outputs = []*Output{{Name: "recorder", Writer: rec}}
+		c.Config.SupervisorRestart = test.policy
+		c.Config.SupervisorRestartDelayMillis = 10
+		c.Config.SupervisorRestartMaxDelaySecs = 1
+		c.Config.SupervisorMaxRestarts = 2
+		c.Config.SupervisorRestartWindowSecs = 60
+
+		s := &Supervisor{
+			Consumer: c,
+			// The last line of the app is incomplete
+			Command: []string{"sh", "-c", "printf run; exit " + strconv.Itoa(test.exitCode)},
+		}
+		code, err := s.Run()
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.exitCode {
+			t.Errorf("Incorrect exit code for %s policy. Expected %d, Got %d", test.policy, test.exitCode, code)
+		}
+
+		lines := rec.getLines()
+		if runs := len(streamLines(lines, "stdout")); runs != test.runs {
+			t.Errorf("Incorrect no. of runs for %s policy. Expected %d, Got %d", test.policy, test.runs, runs)
+		}
+		var events []string
+		for _, line := range streamLines(lines, "supervisor") {
+			events = append(events, strings.TrimSuffix(strings.TrimPrefix(line, "stream=supervisor "), "\n"))
+		}
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("Incorrect events for %s policy. Expected %q, Got %q", test.policy, test.events, events)
+		}
+	}
+}
+
+// streamLines returns the lines which were tagged with the stream
+func streamLines(lines []string, stream string) []string {
+	var res []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "stream="+stream+" ") {
+			res = append(res, line)
+		}
+	}
+	return res
+}
+
+func TestSupervisorNoCommand(t *testing.T) {
+	s := &Supervisor{Consumer: &Consumer{}}
+	if _, err := s.Run(); err != ErrNoCommand {
+		t.Errorf("Expected %v, Got %v", ErrNoCommand, err)
+	}
+}